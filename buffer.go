@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// buffer - a board open in the editor with its own interpreter,
+// and where the cursor and screen were when the user last looked at it
+type buffer struct {
+	changes  int64 // edits submitted by the editor
+	saved    int64 // changes when the board was last saved to filename, set by the interpreter
+	filename string
+	layout   layout // the shape of the file, kept when saving
+	m        *machine
+	cursor   coord
+	origin   coord
+}
+
+var buffers []*buffer
+var currentBuffer int
+var buffersMutex sync.RWMutex
+
+// current() - the buffer on the screen
+func current() *buffer {
+	buffersMutex.RLock()
+	defer buffersMutex.RUnlock()
+	return buffers[currentBuffer]
+}
+
+// addBuffer() - start an interpreter over board b, loaded from a file shaped like l, and switch to it
+func addBuffer(filename string, b board, l layout) {
+	m := newMachine(b, l.settings)
+	buffersMutex.Lock()
+	buffers = append(buffers, &buffer{filename: filename, layout: l, m: m, cursor: coord{cursorX, cursorY}})
+	buffersMutex.Unlock()
+	switchBuffer(len(buffers) - 1)
+}
+
+// openBuffer() - switch to filename if it is open, otherwise load it into a new buffer.
+// A file which does not exist yet opens as an empty board.
+func openBuffer(filename string) error {
+	for i, bu := range buffers {
+		if bu.filename == filename {
+			switchBuffer(i)
+			return nil
+		}
+	}
+	b, l, err := loadFile(filename)
+	if os.IsNotExist(err) {
+		b, l = makeBoard(), layout{}
+		setMiddleMsg(fmt.Sprintf("New file %s", filename))
+	} else if err != nil {
+		return err
+	}
+	addBuffer(filename, b, l)
+	return nil
+}
+
+// changed() - count an edit from the editor
+func (bu *buffer) changed() {
+	atomic.AddInt64(&bu.changes, 1)
+}
+
+// dirty() - has the board been edited since it was last saved
+func (bu *buffer) dirty() bool {
+	return atomic.LoadInt64(&bu.changes) != atomic.LoadInt64(&bu.saved)
+}
+
+// save() - the edit which saves the board to filename. Saving to the buffer's
+// own file marks it clean up to the edits submitted before the save.
+func (bu *buffer) save(filename string) edit {
+	changes := atomic.LoadInt64(&bu.changes)
+	own := filename == bu.filename
+	l := bu.layout
+	return func(b board) {
+		l.settings = l.settings.update(bu.m)
+		if err := b.saveFile(filename, l); err != nil {
+			setMiddleMsg(err.Error())
+			return
+		}
+		if own {
+			atomic.StoreInt64(&bu.saved, changes)
+		}
+	}
+}
+
+// write() - save the board to its own file
+func (bu *buffer) write() {
+	bu.m.submit(bu.save(bu.filename))
+}
+
+// unsaved() - the names of the boards with changes which have not been saved
+func unsaved() []string {
+	buffersMutex.RLock()
+	defer buffersMutex.RUnlock()
+	names := make([]string, 0)
+	for _, bu := range buffers {
+		if bu.dirty() {
+			names = append(names, bu.filename)
+		}
+	}
+	return names
+}
+
+// autosaveAll() - save every board with unsaved changes
+func autosaveAll() {
+	buffersMutex.RLock()
+	defer buffersMutex.RUnlock()
+	for _, bu := range buffers {
+		if bu.dirty() {
+			bu.write()
+		}
+	}
+}
+
+// switchBuffer() - show buffer i, keeping the cursor and screen position of the one we leave
+func switchBuffer(i int) {
+	buffersMutex.Lock()
+	defer buffersMutex.Unlock()
+	if len(buffers) > 1 {
+		old := buffers[currentBuffer]
+		old.cursor = coord{cursorX, cursorY}
+		old.origin = theViewport.origin
+	}
+	currentBuffer = (i + len(buffers)) % len(buffers)
+	bu := buffers[currentBuffer]
+	cursorX, cursorY = bu.cursor.x, bu.cursor.y
+	theViewport.origin = bu.origin
+	drawStyle = bu.layout.settings.renderStyle()
+	theEditor.noShift()
+}
+
+// closeBuffer() - stop the interpreter of the current buffer and show the next, unless it is the last.
+// Unsaved changes are only thrown away when forced.
+func closeBuffer(force bool) error {
+	buffersMutex.Lock()
+	if len(buffers) == 1 {
+		buffersMutex.Unlock()
+		return fmt.Errorf("can't close the last board")
+	}
+	closing := buffers[currentBuffer]
+	if closing.dirty() && !force {
+		buffersMutex.Unlock()
+		return fmt.Errorf("%s has unsaved changes, :w or :close!", closing.filename)
+	}
+	buffers = append(buffers[:currentBuffer], buffers[currentBuffer+1:]...)
+	currentBuffer = currentBuffer % len(buffers)
+	bu := buffers[currentBuffer]
+	buffersMutex.Unlock()
+	closing.m.stop()
+	cursorX, cursorY = bu.cursor.x, bu.cursor.y
+	theViewport.origin = bu.origin
+	drawStyle = bu.layout.settings.renderStyle()
+	theEditor.noShift()
+	return nil
+}
+
+// bufferList() - a line for each open buffer, the current one marked with '*' and unsaved ones with '+'
+func bufferList() []string {
+	buffersMutex.RLock()
+	defer buffersMutex.RUnlock()
+	lines := make([]string, 0, len(buffers))
+	for i, bu := range buffers {
+		mark, changed := ' ', ' '
+		if i == currentBuffer {
+			mark = '*'
+		}
+		if bu.dirty() {
+			changed = '+'
+		}
+		lines = append(lines, fmt.Sprintf(" %c%2d %c %s ", mark, i+1, changed, bu.filename))
+	}
+	return lines
+}
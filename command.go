@@ -0,0 +1,341 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gdamore/tcell"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// command - something typed on the command line, like ":w name"
+type command struct {
+	name     string
+	usage    string
+	help     string
+	run      func(args []string) error
+	complete func(arg string) []string // candidates for the last argument, may be nil
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"w", "w [file]", "save the board, to file if given", commandWrite, completeFile},
+		{"saveas", "saveas file", "save the board to file and keep saving there", commandSaveAs, completeFile},
+		{"e", "e file", "open file as another board", commandEdit, completeFile},
+		{"ls", "ls", "list the open boards", commandList, nil},
+		{"b", "b n", "show board n", commandBuffer, nil},
+		{"bn", "bn", "show the next board", commandNext, nil},
+		{"bp", "bp", "show the previous board", commandPrevious, nil},
+		{"close", "close", "close the board", commandClose, nil},
+		{"close!", "close!", "close the board, throwing away unsaved changes", commandCloseForced, nil},
+		{"export", "export file [ticks]", "draw the selection or board into an .svg or .png, after running a copy for ticks", commandExport, completeFile},
+		{"record", "record file [every]", "record the selection or board into a .gif or .cast every so many ticks, record stop to finish", commandRecord, completeFile},
+		{"verilog", "verilog file.v", "export the board as structural Verilog, listing what can't be", commandVerilog, completeFile},
+		{"import", "import netlist [file]", "place a gate netlist as a new board, named file or after the netlist", commandImport, completeFile},
+		{"truth", "truth [file]", "the truth table of the '*' inputs and lamps in the selection or board, only those named by a comment if any are, saved to file if given", commandTruth, completeFile},
+		{"lint", "lint [off]", "list and mark wiring which can't work, off clears the marks", commandLint, completeWords("off")},
+		{"inspect", "inspect", "show or hide the panel explaining the cell under the mouse or cursor, also F10", commandInspect, nil},
+		{"signals", "signals on|off", "colour the wires by the values they carried in the last tick, on to start with", commandSignals, completeWords("on", "off")},
+		{"speed", "speed duration", "set the clock speed, like 10ms", commandSpeed, nil},
+		{"goto", "goto x y", "move the cursor to x y", commandGoto, nil},
+		{"seed", "seed n", "seed the random numbers used by R cells, saved with the board", commandSeed, nil},
+		{"style", "style plain|unicode", "draw the wires of this board as they are typed or as lines, saved with the board", commandStyle, completeWords("plain", "unicode")},
+		{"macro", "macro reload", "read the macro files again", commandMacro, completeWords("reload")},
+		{"help", "help", "list the commands", commandHelp, nil},
+	}
+}
+
+// commandLine - an ex-style command line opened with Ctrl-P
+type commandLine struct {
+	on    bool
+	input prompt
+	help  []string // lines shown over the board until the next key
+}
+
+var theCommandLine = commandLine{}
+
+func (cl *commandLine) open() {
+	cl.on = true
+	cl.input = prompt{label: ":"}
+}
+
+// handle() - keys while the command line is open
+func (cl *commandLine) handle(ev *tcell.EventKey) {
+	switch ev.Key() {
+	case tcell.KeyEscape:
+		cl.on = false
+	case tcell.KeyEnter:
+		cl.on = false
+		if err := execute(string(cl.input.text)); err != nil {
+			setMiddleMsg(err.Error())
+		}
+	case tcell.KeyTab:
+		cl.input.text = []rune(complete(string(cl.input.text)))
+	default:
+		cl.input.handle(ev)
+	}
+}
+
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+// execute() - run a command line
+func execute(line string) error {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+	c, ok := findCommand(fields[0])
+	if !ok {
+		return fmt.Errorf("unknown command %s, try :help", fields[0])
+	}
+	return c.run(fields[1:])
+}
+
+// complete() - extend the last word of line as far as the candidates agree,
+// listing them when there is more than one
+func complete(line string) string {
+	fields := strings.Fields(line)
+	if strings.HasSuffix(line, " ") || len(fields) == 0 {
+		fields = append(fields, "")
+	}
+	last := fields[len(fields)-1]
+	var candidates []string
+	if len(fields) == 1 {
+		for _, c := range commands {
+			if strings.HasPrefix(c.name, last) {
+				candidates = append(candidates, c.name)
+			}
+		}
+	} else if c, ok := findCommand(fields[0]); ok && c.complete != nil {
+		candidates = c.complete(last)
+	}
+	if len(candidates) == 0 {
+		return line
+	}
+	sort.Strings(candidates)
+	if len(candidates) > 1 {
+		setMiddleMsg(strings.Join(candidates, " "))
+	}
+	common := candidates[0]
+	for _, c := range candidates[1:] {
+		for !strings.HasPrefix(c, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	fields[len(fields)-1] = common
+	completed := strings.Join(fields, " ")
+	if len(candidates) == 1 && len(fields) == 1 {
+		completed += " "
+	}
+	return completed
+}
+
+func completeFile(arg string) []string {
+	names, _ := filepath.Glob(arg + "*")
+	return names
+}
+
+func completeWords(words ...string) func(arg string) []string {
+	return func(arg string) []string {
+		found := make([]string, 0)
+		for _, w := range words {
+			if strings.HasPrefix(w, arg) {
+				found = append(found, w)
+			}
+		}
+		return found
+	}
+}
+
+func commandWrite(args []string) error {
+	bu := current()
+	name := bu.filename
+	if len(args) > 0 {
+		name = args[0]
+	}
+	bu.m.submit(bu.save(name))
+	return nil
+}
+
+func commandSaveAs(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: saveas file")
+	}
+	bu := current()
+	bu.filename = args[0]
+	bu.write()
+	return nil
+}
+
+func commandEdit(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: e file")
+	}
+	return openBuffer(args[0])
+}
+
+func commandList(args []string) error {
+	theCommandLine.help = bufferList()
+	return nil
+}
+
+func commandBuffer(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: b n")
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 || n > len(buffers) {
+		return fmt.Errorf("no board %s, try :ls", args[0])
+	}
+	switchBuffer(n - 1)
+	return nil
+}
+
+func commandNext(args []string) error {
+	switchBuffer(currentBuffer + 1)
+	return nil
+}
+
+func commandPrevious(args []string) error {
+	switchBuffer(currentBuffer - 1)
+	return nil
+}
+
+func commandClose(args []string) error {
+	return closeBuffer(false)
+}
+
+func commandCloseForced(args []string) error {
+	return closeBuffer(true)
+}
+
+func commandSpeed(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: speed duration")
+	}
+	d, err := time.ParseDuration(args[0])
+	if err != nil {
+		return err
+	}
+	m := current().m
+	m.submit(func(b board) {
+		m.clockSpeed = d
+	})
+	return nil
+}
+
+func commandGoto(args []string) error {
+	p, ok := parseCoord(strings.Join(args, " "))
+	if !ok {
+		return fmt.Errorf("usage: goto x y")
+	}
+	goTo(p)
+	return nil
+}
+
+func commandSeed(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: seed n")
+	}
+	n, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return err
+	}
+	bu := current()
+	bu.changed()
+	bu.m.submit(func(b board) {
+		bu.m.reseed(n)
+	})
+	return nil
+}
+
+// commandStyle() - :style plain|unicode, how to draw this board, saved with it
+func commandStyle(args []string) error {
+	if len(args) != 1 || (args[0] != "plain" && args[0] != "unicode") {
+		return fmt.Errorf("usage: style plain|unicode")
+	}
+	bu := current()
+	bu.layout.settings = bu.layout.settings.set("renderStyle", strconv.Quote(args[0]))
+	bu.changed()
+	drawStyle = args[0]
+	return nil
+}
+
+func commandMacro(args []string) error {
+	if len(args) != 1 || args[0] != "reload" {
+		return fmt.Errorf("usage: macro reload")
+	}
+	m := current().m
+	m.submit(func(b board) {
+		m.macros = make(map[string]board)
+	})
+	return nil
+}
+
+// keyHelp - the keys bound in main()
+var keyHelp = []string{
+	" Ctrl-Q            quit, twice when there are unsaved changes ",
+	" Ctrl-S, F4        save ",
+	" Ctrl-P            command line ",
+	" Ctrl-O Ctrl-N     open a board, show the next board ",
+	" F5                toggle the value under the cursor ",
+	" Shift-arrows      select ",
+	" Ctrl-C Ctrl-X     copy, cut the selection ",
+	" Ctrl-V Ctrl-B     paste, paste without the blanks ",
+	" F6 F7 F8          rotate, mirror the selection or paste buffer ",
+	" F9                move the selection with the arrows ",
+	" Ctrl-W            mark the start then the end of a wire ",
+	" Ctrl-F Ctrl-R F3  find, replace, find next ",
+	" Ctrl-G            go to a label or x,y ",
+	" F2                overview ",
+	" F10               inspect the cell under the mouse or cursor ",
+	" F11               highlight everything connected to the cursor ",
+	" PgUp PgDn Home End  scroll a screen ",
+}
+
+func commandHelp(args []string) error {
+	lines := make([]string, 0, len(commands)+len(keyHelp))
+	for _, c := range commands {
+		lines = append(lines, fmt.Sprintf(" :%-16s %s ", c.usage, c.help))
+	}
+	theCommandLine.help = append(lines, keyHelp...)
+	return nil
+}
+
+// view() - draw the help listing over the board and the command line on the status line
+func (cl *commandLine) view(s tcell.Screen) {
+	v := theViewport
+	sty := tcell.StyleDefault.Background(tcell.ColorDarkSlateGray).Foreground(tcell.ColorWhite)
+	for y, line := range cl.help {
+		if y >= v.height {
+			break
+		}
+		runes := []rune(line)
+		for x := 0; x < v.width; x++ {
+			r := ' '
+			if x < len(runes) {
+				r = runes[x]
+			}
+			s.SetContent(x, y, r, nil, sty)
+		}
+	}
+	if !cl.on {
+		return
+	}
+	for x := 0; x < v.width; x++ {
+		s.SetContent(x, v.height, ' ', nil, tcell.StyleDefault)
+	}
+	setLeftMsg(cl.input.String())
+	s.SetContent(len([]rune(cl.input.String())), v.height, ' ', nil, tcell.StyleDefault.Reverse(true))
+}
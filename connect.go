@@ -0,0 +1,76 @@
+package main
+
+import (
+	"github.com/gdamore/tcell"
+)
+
+// Net highlighting follows the cursor, marking every cell electrically connected to it by
+// the rules trace() shares with propagate(), with the one way parts in their own colours.
+
+// netHighlight - F11 turns it on and off
+type netHighlight struct {
+	on bool
+}
+
+var theNetHighlight = netHighlight{}
+
+// netCells - the cells connected to the cursor
+type netCells map[coord]bool
+
+// connected() - the cells electrically connected to p on b: the nets of the components
+// driving it, driven by it or feeding it, and everywhere a signal put into p would go
+func connected(b board, nets *netCache, p coord) netCells {
+	cells := netCells{p: true}
+	drivers := append([]coord{}, nets.on[p]...)
+	if _, ok := nets.n.wires[p]; ok {
+		drivers = append(drivers, p)
+	}
+	for _, a := range nets.n.arrivals[p] {
+		drivers = append(drivers, a.driver)
+	}
+	for _, d := range drivers {
+		cells[d] = true
+		for _, w := range nets.n.wires[d] {
+			cells[w] = true
+		}
+		for _, c := range nets.reaches(d) {
+			cells[c] = true
+		}
+	}
+	outs := make([]coord, 0, 4)
+	for _, side := range []coord{{p.x, p.y - 1}, {p.x, p.y + 1}, {p.x + 1, p.y}, {p.x - 1, p.y}} {
+		if emitsInto(b, p, side) {
+			outs = append(outs, side)
+		}
+	}
+	trace(b, p, outs, func(w coord) {
+		cells[w] = true
+	}, func(c coord, _ arrival) {
+		cells[c] = true
+	})
+	return cells
+}
+
+// cells() - what to highlight on pub from m, nothing when off
+func (nh *netHighlight) cells(m *machine, pub *publication) netCells {
+	if !nh.on {
+		return nil
+	}
+	return connected(pub.b, theNets.get(m, pub.edits, pub.b), coord{cursorX, cursorY})
+}
+
+// mark() - mark the connected cells of b, diodes and buffers apart as signals only go one way through them
+func (c netCells) mark(b board, m marks) {
+	for p := range c {
+		switch b.getC(p) {
+		case '>', '<':
+			m[p] = tcell.ColorMediumPurple
+		case '~':
+			m[p] = tcell.ColorOlive
+		case '-', '|', '@', '/', '\\':
+			m[p] = tcell.ColorDarkCyan
+		default:
+			m[p] = tcell.ColorSteelBlue
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"github.com/gdamore/tcell"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Exports draw the board into an SVG or PNG image the way view() shows it,
+// with the same colours and box drawing runes, for diagrams in the docs.
+
+// exportCell - a cell as view() draws it
+type exportCell struct {
+	r    rune // after fancy()
+	fg   color.RGBA
+	bg   color.RGBA
+	bold bool
+}
+
+// the colours of the screen where a style leaves them as the default
+var exportForeground = color.RGBA{0xff, 0xff, 0xff, 0xff}
+var exportBackground = color.RGBA{0x00, 0x00, 0x00, 0xff}
+
+// SVG cells are in pixels, PNG cells in the pixels of the glyphs which are pngScale pixels square
+const svgCellWidth = 10
+const svgCellHeight = 20
+const svgFontSize = 16
+const pngCellWidth = 6
+const pngCellHeight = 9
+const pngScale = 2
+
+func rgba(c tcell.Color, otherwise color.RGBA) color.RGBA {
+	if c == tcell.ColorDefault {
+		return otherwise
+	}
+	r, g, b := c.RGB()
+	if r < 0 {
+		return otherwise
+	}
+	return color.RGBA{uint8(r), uint8(g), uint8(b), 0xff}
+}
+
+// exportCells() - the cells of b inside r, row by row, drawn in the render style
+func exportCells(b board, r rectangle, style string) [][]exportCell {
+	rows := make([][]exportCell, 0, r.bottomRight.y-r.topLeft.y+1)
+	for y := r.topLeft.y; y <= r.bottomRight.y; y++ {
+		row := make([]exportCell, 0, r.bottomRight.x-r.topLeft.x+1)
+		styleRow(b, y, r.topLeft.x, r.bottomRight.x+1, func(x int, val rune, sty tcell.Style) {
+			if val == 0 {
+				val = ' '
+			}
+			fg, bg, attr := sty.Decompose()
+			row = append(row, exportCell{fancyIn(style, val), rgba(fg, exportForeground), rgba(bg, exportBackground), attr&tcell.AttrBold != 0})
+		})
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// writeSVG() - the cells as text over coloured rectangles, with the box drawing runes
+// drawn as lines so that wires join up whatever the font
+func writeSVG(w io.Writer, cells [][]exportCell) error {
+	var out bytes.Buffer
+	width, height := 0, len(cells)*svgCellHeight
+	if len(cells) > 0 {
+		width = len(cells[0]) * svgCellWidth
+	}
+	fmt.Fprintf(&out, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="monospace" font-size="%d" text-anchor="middle">`+"\n", width, height, svgFontSize)
+	fmt.Fprintf(&out, `<rect width="%d" height="%d" fill="%s"/>`+"\n", width, height, hexColor(exportBackground))
+	rect := func(x, y, w, h int, c color.RGBA) {
+		fmt.Fprintf(&out, `<rect x="%d" y="%d" width="%d" height="%d" fill="%s"/>`+"\n", x, y, w, h, hexColor(c))
+	}
+	for y, row := range cells {
+		for x, c := range row {
+			px, py := x*svgCellWidth, y*svgCellHeight
+			if c.bg != exportBackground {
+				rect(px, py, svgCellWidth, svgCellHeight, c.bg)
+			}
+			switch c.r {
+			case ' ':
+			case '│':
+				rect(px+svgCellWidth/2-1, py, 2, svgCellHeight, c.fg)
+			case '─':
+				rect(px, py+svgCellHeight/2-1, svgCellWidth, 2, c.fg)
+			case '█':
+				rect(px, py, svgCellWidth, svgCellHeight, c.fg)
+			default:
+				weight := ""
+				if c.bold {
+					weight = ` font-weight="bold"`
+				}
+				fmt.Fprintf(&out, `<text x="%d" y="%d" fill="%s"%s>`, px+svgCellWidth/2, py+svgFontSize-1, hexColor(c.fg), weight)
+				_ = xml.EscapeText(&out, []byte(string(c.r)))
+				out.WriteString("</text>\n")
+			}
+		}
+	}
+	out.WriteString("</svg>\n")
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// drawPNG() - the cells drawn with the glyphs of font.go, and the box drawing runes as lines
+func drawPNG(cells [][]exportCell) *image.RGBA {
+	cw, ch := pngCellWidth*pngScale, pngCellHeight*pngScale
+	width := 0
+	if len(cells) > 0 {
+		width = len(cells[0])
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width*cw, len(cells)*ch))
+	fill := func(r image.Rectangle, c color.RGBA) {
+		draw.Draw(img, r, &image.Uniform{c}, image.Point{}, draw.Src)
+	}
+	for y, row := range cells {
+		for x, c := range row {
+			px, py := x*cw, y*ch
+			fill(image.Rect(px, py, px+cw, py+ch), c.bg)
+			switch c.r {
+			case ' ':
+			case '│':
+				fill(image.Rect(px+cw/2-pngScale/2, py, px+cw/2+pngScale/2, py+ch), c.fg)
+			case '─':
+				fill(image.Rect(px, py+ch/2-pngScale/2, px+cw, py+ch/2+pngScale/2), c.fg)
+			case '█':
+				fill(image.Rect(px, py, px+cw, py+ch), c.fg)
+			default:
+				// the glyph sits one font pixel down from the top of the cell
+				for gy, bits := range glyph(c.r) {
+					for gx := 0; gx < 5; gx++ {
+						if bits&(0x10>>gx) == 0 {
+							continue
+						}
+						x0, y0 := px+gx*pngScale, py+(gy+1)*pngScale
+						x1 := x0 + pngScale
+						if c.bold {
+							x1 += pngScale / 2
+						}
+						fill(image.Rect(x0, y0, x1, y0+pngScale), c.fg)
+					}
+				}
+			}
+		}
+	}
+	return img
+}
+
+// exportFile() - draw the cells of b inside r into filename, as SVG or PNG by its extension
+func exportFile(b board, r rectangle, style string, filename string) error {
+	if r.bottomRight.x < r.topLeft.x || r.bottomRight.y < r.topLeft.y {
+		return fmt.Errorf("nothing to export")
+	}
+	cells := exportCells(b, r, style)
+	var data bytes.Buffer
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".svg":
+		if err := writeSVG(&data, cells); err != nil {
+			return err
+		}
+	case ".png":
+		if err := png.Encode(&data, drawPNG(cells)); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("can only export to .svg or .png files, not %s", filename)
+	}
+	return replaceFile(filename, data.Bytes())
+}
+
+func parseTicks(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("ticks must be a number from 0 up, not %s", s)
+	}
+	return n, nil
+}
+
+// commandExport() - :export file [ticks], the selection or else the whole board,
+// as it is now or after running a copy of it for some more ticks
+func commandExport(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("usage: export file.svg|file.png [ticks]")
+	}
+	ticks := 0
+	if len(args) == 2 {
+		var err error
+		if ticks, err = parseTicks(args[1]); err != nil {
+			return err
+		}
+	}
+	b := makeBoard()
+	snapshot(func(s board, _ int) {
+		s.copyInto(b)
+	})
+	selection := theEditor.ks != KeysNormal
+	r := theEditor.selectionRectangle
+	s := current().layout.settings
+	style := s.renderStyle()
+	m := headlessMachine(b) // a copy, so the delays in progress start again
+	s.apply(m)
+	return background("Exporting "+args[0], func(j *job) error {
+		for i := 0; i < ticks; i++ {
+			if j.cancelled() {
+				return nil
+			}
+			j.progress("tick %d of %d", i, ticks)
+			m.step()
+		}
+		if !selection {
+			r = b.extent()
+		}
+		return exportFile(b, r, style, args[0])
+	}, func() error {
+		setMiddleMsg(fmt.Sprintf("Exported %s", args[0]))
+		return nil
+	})
+}
+
+// exportMain() - betula export board.betula image.svg|image.png [ticks], without the editor
+func exportMain(args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return fmt.Errorf("usage: betula export board.betula image.svg|image.png [ticks]")
+	}
+	ticks := 0
+	if len(args) == 3 {
+		var err error
+		if ticks, err = parseTicks(args[2]); err != nil {
+			return err
+		}
+	}
+	headlessInit()
+	b, l, err := loadFile(args[0])
+	if err != nil {
+		return err
+	}
+	m := headlessMachine(b)
+	l.settings.apply(m)
+	m.run(ticks)
+	return exportFile(b, b.extent(), l.settings.renderStyle(), args[1])
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gdamore/tcell"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// prompt - a line of text typed on the status line
+type prompt struct {
+	label string
+	text  []rune
+}
+
+// handle() - edit the text, returns true when the key was used
+func (p *prompt) handle(ev *tcell.EventKey) bool {
+	switch ev.Key() {
+	case tcell.KeyRune:
+		p.text = append(p.text, ev.Rune())
+	case tcell.KeyBackspace, tcell.KeyBackspace2:
+		if len(p.text) > 0 {
+			p.text = p.text[:len(p.text)-1]
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+func (p *prompt) String() string {
+	return p.label + string(p.text)
+}
+
+// label - a comment on the board and the location of its opening '_'
+type label struct {
+	text string
+	at   coord
+}
+
+// finder - pick a comment label from a fuzzy filtered list, or type x,y, and move the cursor there
+type finder struct {
+	on       bool
+	input    prompt
+	labels   []label
+	matches  []label
+	selected int
+}
+
+var theFinder = finder{}
+
+// open() - start a finder over the comments of board b
+func (f *finder) open(b board) {
+	f.on = true
+	f.input = prompt{label: "go to label or x,y: "}
+	f.labels = b.comments()
+	f.filter()
+}
+
+// filter() - the labels matching the typed text, best first
+func (f *finder) filter() {
+	type scored struct {
+		label
+		score int
+	}
+	found := make([]scored, 0)
+	for _, l := range f.labels {
+		if score, ok := fuzzyMatch(string(f.input.text), l.text); ok {
+			found = append(found, scored{l, score})
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].score < found[j].score
+	})
+	f.matches = f.matches[:0]
+	for _, s := range found {
+		f.matches = append(f.matches, s.label)
+	}
+	f.selected = 0
+}
+
+// fuzzyMatch() - are the runes of pattern in text in order, ignoring case.
+// The score is the number of runes skipped between matches, lower is better.
+func fuzzyMatch(pattern string, text string) (int, bool) {
+	p := []rune(strings.ToLower(pattern))
+	score := 0
+	i := 0
+	skipped := 0
+	for _, r := range strings.ToLower(text) {
+		if i == len(p) {
+			break
+		}
+		if r == p[i] {
+			if i > 0 {
+				score += skipped
+			}
+			skipped = 0
+			i++
+			continue
+		}
+		skipped++
+	}
+	return score, i == len(p)
+}
+
+// parseCoord() - read "x,y" or "x y"
+func parseCoord(s string) (coord, bool) {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	if len(fields) != 2 {
+		return nowhere, false
+	}
+	x, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return nowhere, false
+	}
+	y, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return nowhere, false
+	}
+	return coord{x, y}, true
+}
+
+// goTo() - move the cursor to p and scroll it into the middle of the screen
+func goTo(p coord) {
+	cursorX, cursorY = p.x, p.y
+	theViewport.center(p)
+}
+
+// handle() - keys while the finder is open
+func (f *finder) handle(ev *tcell.EventKey) {
+	switch ev.Key() {
+	case tcell.KeyEscape, tcell.KeyCtrlG:
+		f.on = false
+	case tcell.KeyEnter:
+		if p, ok := parseCoord(string(f.input.text)); ok {
+			goTo(p)
+		} else if len(f.matches) > 0 {
+			goTo(f.matches[f.selected].at)
+		} else {
+			return // nothing to go to
+		}
+		f.on = false
+	case tcell.KeyUp:
+		if f.selected > 0 {
+			f.selected -= 1
+		}
+	case tcell.KeyDown:
+		if f.selected < len(f.matches)-1 {
+			f.selected += 1
+		}
+	default:
+		if f.input.handle(ev) {
+			f.filter()
+		}
+	}
+}
+
+// view() - draw the matching labels over the top of the board
+func (f *finder) view(s tcell.Screen) {
+	v := theViewport
+	rows := minInt(len(f.matches), v.height)
+	first := maxInt(f.selected-rows+1, 0)
+	for i := 0; i < rows; i++ {
+		l := f.matches[first+i]
+		line := []rune(fmt.Sprintf(" %4d %4d  %s ", l.at.x, l.at.y, l.text))
+		sty := tcell.StyleDefault.Background(tcell.ColorDarkSlateGray).Foreground(tcell.ColorWhite)
+		if first+i == f.selected {
+			sty = sty.Reverse(true)
+		}
+		for x := 0; x < v.width; x++ {
+			r := ' '
+			if x < len(line) {
+				r = line[x]
+			}
+			s.SetContent(x, i, r, nil, sty)
+		}
+	}
+	for x := 0; x < v.width; x++ {
+		s.SetContent(x, v.height, ' ', nil, tcell.StyleDefault)
+	}
+	setLeftMsg(f.input.String())
+	s.SetContent(len([]rune(f.input.String())), v.height, ' ', nil, tcell.StyleDefault.Reverse(true))
+}
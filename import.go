@@ -0,0 +1,470 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Importing turns a gate level netlist designed elsewhere into a board. The gates are placed
+// in columns by how many gates come before them, inputs on the left and lamps for the outputs
+// on the right, and the wires are laid by route(). The netlist is either text, like
+//
+//	# half adder
+//	input a b
+//	output sum carry
+//	sum = XOR a b
+//	carry = AND a b
+//
+// or the same as JSON
+//
+//	{"inputs": ["a", "b"], "outputs": ["sum", "carry"],
+//	 "gates": [{"name": "sum", "type": "XOR", "inputs": ["a", "b"]}, ...]}
+
+// importGates - the gates a netlist may use and their runes
+var importGates = map[string]rune{"AND": '.', "OR": '+', "XOR": '#', "NAND": '^', "NOT": 'N'}
+
+type netlistGate struct {
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	Inputs []string `json:"inputs"`
+}
+
+type gateNetlist struct {
+	Inputs  []string      `json:"inputs"`
+	Outputs []string      `json:"outputs"`
+	Gates   []netlistGate `json:"gates"`
+}
+
+// spacing of the placed components
+const importInputPitch = 4 // rows between inputs and between outputs
+const importGatePitch = 8  // rows between gates, room for a wire either side of the inputs
+const importColumnGap = 6  // columns between columns of gates, and two more for each wire into them
+
+// importAttempts - how many times to try wiring, routing the wires which failed first each time
+const importAttempts = 10
+
+// importReserved - what holds a cell for a component while the wires are routed around it
+const importReserved = '%'
+
+// parseNetlist() - read text, JSON if it starts with '{'
+func parseNetlist(text []byte) (gateNetlist, error) {
+	var n gateNetlist
+	if bytes.HasPrefix(bytes.TrimSpace(text), []byte("{")) {
+		if err := json.Unmarshal(text, &n); err != nil {
+			return n, err
+		}
+		return n, n.check()
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(text))
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(strings.SplitN(scanner.Text(), "#", 2)[0])
+		switch {
+		case len(fields) == 0:
+		case fields[0] == "input":
+			n.Inputs = append(n.Inputs, fields[1:]...)
+		case fields[0] == "output":
+			n.Outputs = append(n.Outputs, fields[1:]...)
+		case len(fields) >= 3 && fields[1] == "=":
+			n.Gates = append(n.Gates, netlistGate{fields[0], fields[2], fields[3:]})
+		default:
+			return n, fmt.Errorf("line %d: expected input, output or name = GATE inputs", line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return n, err
+	}
+	return n, n.check()
+}
+
+// check() - every signal is defined once and every gate has the inputs it needs
+func (n gateNetlist) check() error {
+	defined := map[string]bool{}
+	define := func(name string) error {
+		if defined[name] {
+			return fmt.Errorf("%s is defined more than once", name)
+		}
+		defined[name] = true
+		return nil
+	}
+	for _, in := range n.Inputs {
+		if err := define(in); err != nil {
+			return err
+		}
+	}
+	for _, g := range n.Gates {
+		if err := define(g.Name); err != nil {
+			return err
+		}
+		if _, ok := importGates[strings.ToUpper(g.Type)]; !ok {
+			return fmt.Errorf("%s: unknown gate %s, expected AND, OR, XOR, NAND or NOT", g.Name, g.Type)
+		}
+		if want := map[bool]int{true: 1, false: 2}[strings.ToUpper(g.Type) == "NOT"]; len(g.Inputs) != want {
+			return fmt.Errorf("%s: %s takes %d inputs, not %d", g.Name, g.Type, want, len(g.Inputs))
+		}
+	}
+	for _, g := range n.Gates {
+		for _, in := range g.Inputs {
+			if !defined[in] {
+				return fmt.Errorf("%s: %s is not an input or a gate", g.Name, in)
+			}
+		}
+	}
+	for _, out := range n.Outputs {
+		if !defined[out] {
+			return fmt.Errorf("output %s is not an input or a gate", out)
+		}
+	}
+	return nil
+}
+
+// levels() - how many gates come before each signal, the inputs being 0.
+// A loop is broken where it gets back to a gate already being levelled.
+func (n gateNetlist) levels() map[string]int {
+	gates := map[string]netlistGate{}
+	for _, g := range n.Gates {
+		gates[g.Name] = g
+	}
+	level := map[string]int{}
+	for _, in := range n.Inputs {
+		level[in] = 0
+	}
+	levelling := map[string]bool{}
+	var levelOf func(name string) int
+	levelOf = func(name string) int {
+		if l, ok := level[name]; ok {
+			return l
+		}
+		levelling[name] = true
+		l := 1
+		for _, in := range gates[name].Inputs {
+			if !levelling[in] {
+				l = maxInt(l, levelOf(in)+1)
+			}
+		}
+		levelling[name] = false
+		level[name] = l
+		return l
+	}
+	for _, g := range n.Gates {
+		levelOf(g.Name)
+	}
+	return level
+}
+
+// pin - where a wire joins a component, and the cell of the component it joins
+type pin struct {
+	at        coord
+	component coord
+}
+
+// placement - the components of a netlist on a board, with the pins still to be wired
+type placement struct {
+	b        board
+	sources  map[string]pin   // where each signal leaves its component
+	sinks    map[string][]pin // where each signal goes into components
+	reserved []coord          // cells to keep clear, holding importReserved until the end
+}
+
+func (pl *placement) reserve(cs ...coord) {
+	for _, c := range cs {
+		pl.b.setC(c, importReserved)
+		pl.reserved = append(pl.reserved, c)
+	}
+}
+
+func (pl *placement) sink(signal string, at coord, component coord) {
+	pl.reserve(at)
+	pl.sinks[signal] = append(pl.sinks[signal], pin{at, component})
+}
+
+func (pl *placement) label(x int, y int, name string) {
+	for i, r := range "_" + name + "_" {
+		pl.b.set(x+i, y, r)
+	}
+}
+
+// place() - put the components of n on a board, each column of gates ordered by
+// where their inputs come from and centred on the tallest column
+func (n gateNetlist) place() *placement {
+	pl := &placement{b: makeBoard(), sources: map[string]pin{}, sinks: map[string][]pin{}}
+	level := n.levels()
+	columns := make([][]netlistGate, 0)
+	for _, g := range n.Gates {
+		for len(columns) < level[g.Name] {
+			columns = append(columns, nil)
+		}
+		columns[level[g.Name]-1] = append(columns[level[g.Name]-1], g)
+	}
+	height := (len(n.Inputs) - 1) * importInputPitch
+	height = maxInt(height, (len(n.Outputs)-1)*importInputPitch)
+	for _, column := range columns {
+		height = maxInt(height, (len(column)-1)*importGatePitch)
+	}
+	row := map[string]int{}
+	// across() - the y of the i'th of count things pitch apart, centred
+	across := func(i int, count int, pitch int) int {
+		return 2 + (height-(count-1)*pitch)/2 + i*pitch
+	}
+	// byInputs() - order things by the average row of the signals going into them
+	byInputs := func(count int, inputs func(i int) []string) []int {
+		order := make([]int, count)
+		weight := make([]float64, count)
+		for i := range order {
+			order[i] = i
+			for _, in := range inputs(i) {
+				weight[i] += float64(row[in]) / float64(len(inputs(i)))
+			}
+		}
+		sort.SliceStable(order, func(i, j int) bool { return weight[order[i]] < weight[order[j]] })
+		return order
+	}
+
+	labelWidth := 0
+	for _, in := range n.Inputs {
+		labelWidth = maxInt(labelWidth, len(in)+2)
+	}
+	x := labelWidth + 2
+	for i, in := range n.Inputs {
+		y := across(i, len(n.Inputs), importInputPitch)
+		row[in] = y
+		pl.label(labelWidth-len(in)-2, y, in)
+		pl.b.set(x-1, y, '0')
+		pl.b.set(x, y, '*')
+		pl.sources[in] = pin{coord{x + 1, y}, coord{x, y}}
+		pl.reserve(coord{x + 1, y}, coord{x, y - 1}, coord{x, y + 1}) // so the wire leaves to the right
+	}
+	for _, column := range columns {
+		wires := 0
+		for _, g := range column {
+			wires += len(g.Inputs)
+		}
+		x += importColumnGap + 2*wires
+		for i, k := range byInputs(len(column), func(i int) []string { return column[i].Inputs }) {
+			g, y := column[k], across(i, len(column), importGatePitch)
+			p := coord{x, y}
+			row[g.Name] = y
+			pl.b.setC(p, importGates[strings.ToUpper(g.Type)])
+			pl.sources[g.Name] = pin{coord{x + 1, y}, p}
+			pl.reserve(coord{x + 1, y})
+			if len(g.Inputs) == 1 {
+				pl.sink(g.Inputs[0], coord{x - 1, y}, p)
+				pl.reserve(coord{x, y - 1}, coord{x, y + 1})
+				continue
+			}
+			pl.sink(g.Inputs[0], coord{x, y - 1}, p)
+			pl.sink(g.Inputs[1], coord{x, y + 1}, p)
+			pl.reserve(coord{x - 1, y - 1}, coord{x - 1, y}, coord{x - 1, y + 1}) // where the gate keeps its inputs
+		}
+	}
+	x += importColumnGap + 2*len(n.Outputs)
+	for i, k := range byInputs(len(n.Outputs), func(i int) []string { return n.Outputs[i : i+1] }) {
+		out, y := n.Outputs[k], across(i, len(n.Outputs), importInputPitch)
+		p := coord{x, y}
+		pl.b.setC(p, 'L')
+		pl.sink(out, coord{x - 1, y}, p)
+		pl.reserve(coord{x, y - 1})
+		pl.label(x+2, y, out)
+	}
+	return pl
+}
+
+// wireRuneBetween() - the rune for a wire at p going straight on to or from q
+func wireRuneBetween(p coord, q coord) rune {
+	if p.y == q.y {
+		return '-'
+	}
+	return '|'
+}
+
+// tappable() - can a wire branch off the wire at p without disturbing it or its neighbours
+func tappable(b board, p coord) bool {
+	left, right, up, down := b.get(p.x-1, p.y), b.get(p.x+1, p.y), b.get(p.x, p.y-1), b.get(p.x, p.y+1)
+	switch b.getC(p) {
+	case '@':
+		return true
+	case '-':
+		return (left == '-' || left == '@') && (right == '-' || right == '@') && nonValue(up) && nonValue(down)
+	case '|':
+		return (up == '|' || up == '@') && (down == '|' || down == '@') && nonValue(left) && nonValue(right)
+	}
+	return false
+}
+
+// connection - a signal to be wired to one of its sinks
+type connection struct {
+	signal string
+	sink   pin
+}
+
+// connections() - every connection to wire, nearest first
+func (pl *placement) connections() []connection {
+	connections := make([]connection, 0)
+	for signal, sinks := range pl.sinks {
+		for _, s := range sinks {
+			connections = append(connections, connection{signal, s})
+		}
+	}
+	distance := func(c connection) int {
+		from := pl.sources[c.signal].at
+		return absInt(from.x-c.sink.at.x) + absInt(from.y-c.sink.at.y)
+	}
+	sort.Slice(connections, func(i, j int) bool {
+		if di, dj := distance(connections[i]), distance(connections[j]); di != dj {
+			return di < dj
+		}
+		if connections[i].sink.at.x != connections[j].sink.at.x {
+			return connections[i].sink.at.x < connections[j].sink.at.x
+		}
+		return connections[i].sink.at.y < connections[j].sink.at.y
+	})
+	return connections
+}
+
+// wire() - route the connections in order, branching off the wire already laid for a
+// signal with more than one sink. The ones which could not be routed are returned.
+func (pl *placement) wire(connections []connection) []connection {
+	failed := make([]connection, 0)
+	laid := map[string][]coord{}
+	for _, c := range connections {
+		source := pl.sources[c.signal]
+		starts := make([]coord, 0)
+		for _, p := range laid[c.signal] {
+			if tappable(pl.b, p) {
+				starts = append(starts, p)
+			}
+		}
+		if len(laid[c.signal]) == 0 {
+			pl.b.setC(source.at, ' ')
+			starts = append(starts, source.at)
+		}
+		pl.b.setC(c.sink.at, ' ')
+		cells, ok := routeFrom(pl.b, starts, c.sink.at)
+		if !ok {
+			pl.b.setC(c.sink.at, importReserved)
+			if len(laid[c.signal]) == 0 {
+				pl.b.setC(source.at, importReserved)
+			}
+			failed = append(failed, c)
+			continue
+		}
+		// straight into and out of the components, branching with '@'
+		first, last := &cells[0], &cells[len(cells)-1]
+		if first.at == source.at && 2*first.at.x-cells[1].at.x == source.component.x && 2*first.at.y-cells[1].at.y == source.component.y {
+			first.r = wireRuneBetween(first.at, cells[1].at)
+		} else if first.at != source.at {
+			first.r = '@'
+		}
+		before := cells[len(cells)-2].at
+		if 2*last.at.x-before.x == c.sink.component.x && 2*last.at.y-before.y == c.sink.component.y {
+			last.r = wireRuneBetween(before, last.at)
+		}
+		for _, cl := range cells {
+			pl.b.setC(cl.at, cl.r)
+			laid[c.signal] = append(laid[c.signal], cl.at)
+		}
+	}
+	for _, p := range pl.reserved {
+		if pl.b.getC(p) == importReserved {
+			pl.b.setC(p, ' ')
+		}
+	}
+	return failed
+}
+
+// without() - the connections of cs which are not in drop, in order
+func without(cs []connection, drop []connection) []connection {
+	dropped := map[connection]bool{}
+	for _, c := range drop {
+		dropped[c] = true
+	}
+	kept := make([]connection, 0, len(cs))
+	for _, c := range cs {
+		if !dropped[c] {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
+
+func absInt(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// importNetlist() - the board for the netlist in filename, and the wires which could not be routed
+func importNetlist(filename string) (board, []problem, error) {
+	text, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	n, err := parseNetlist(text)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	// when wires can't be routed, start again routing them first
+	best, order := (*placement)(nil), n.place().connections()
+	var failed []connection
+	for attempt := 0; attempt < importAttempts; attempt++ {
+		pl := n.place()
+		f := pl.wire(order)
+		if best == nil || len(f) < len(failed) {
+			best, failed = pl, f
+		}
+		if len(f) == 0 {
+			break
+		}
+		order = append(f, without(order, f)...)
+	}
+	problems := make([]problem, 0, len(failed))
+	for _, c := range failed {
+		problems = append(problems, problem{c.sink.component, fmt.Sprintf("no room for the wire from %s", c.signal)})
+	}
+	return best.b, problems, nil
+}
+
+// commandImport() - :import netlist [board], opening the board placed from the netlist
+// as a new unsaved buffer, named like the netlist unless a name is given
+func commandImport(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("usage: import netlist [file.betula]")
+	}
+	name := strings.TrimSuffix(args[0], filepath.Ext(args[0])) + ".betula"
+	if len(args) == 2 {
+		name = args[1]
+	}
+	b, problems, err := importNetlist(args[0])
+	if err != nil {
+		return err
+	}
+	addBuffer(name, b, layout{})
+	current().changed()
+	if len(problems) > 0 {
+		theCommandLine.help = problemLines(fmt.Sprintf("Imported %s with %d problems:", args[0], len(problems)), problems)
+	}
+	setMiddleMsg(fmt.Sprintf("Imported %s as %s", args[0], name))
+	return nil
+}
+
+// importMain() - betula import netlist file.betula, without the editor
+func importMain(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: betula import netlist file.betula")
+	}
+	headlessInit()
+	b, problems, err := importNetlist(args[0])
+	if err != nil {
+		return err
+	}
+	for _, p := range problems {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", args[0], p)
+	}
+	return b.saveFile(args[1], layout{})
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gdamore/tcell"
+	"strings"
+)
+
+// inspector - a panel explaining the cell under the mouse, or under the cursor once a key
+// is pressed: what it is, where its pins are, what it holds and which net it is on
+type inspector struct {
+	on       bool
+	hover    coord
+	hovering bool
+}
+
+var theInspector = inspector{}
+
+// netCache - the netlist of the published board of a machine, traced again after edits
+type netCache struct {
+	m     *machine
+	edits int
+	n     *netlist
+	on    map[coord][]coord // the drivers whose signals pass through each cell
+}
+
+var theNets = netCache{}
+
+// get() - the netlist of b, the board m published after edits edits
+func (nc *netCache) get(m *machine, edits int, b board) *netCache {
+	if nc.n != nil && nc.m == m && nc.edits == edits {
+		return nc
+	}
+	nc.m, nc.edits, nc.n = m, edits, makeNetlist(b)
+	nc.on = map[coord][]coord{}
+	for _, d := range nc.n.drivers {
+		for _, w := range uniqueCoords(nc.n.wires[d]) {
+			nc.on[w] = append(nc.on[w], d)
+		}
+	}
+	return nc
+}
+
+// reaches() - the components the signal of driver d arrives at
+func (nc *netCache) reaches(d coord) []coord {
+	found := map[coord]bool{}
+	for p, arrivals := range nc.n.arrivals {
+		for _, a := range arrivals {
+			if a.driver == d {
+				found[p] = true
+			}
+		}
+	}
+	return sortedCoords(found)
+}
+
+func (in *inspector) toggle() {
+	in.on = !in.on
+	in.hovering = false
+}
+
+// hovered() - the mouse is over p with no buttons down
+func (in *inspector) hovered(p coord) {
+	in.hover, in.hovering = p, true
+}
+
+// target() - the cell to explain
+func (in *inspector) target() coord {
+	if in.hovering {
+		return in.hover
+	}
+	return coord{cursorX, cursorY}
+}
+
+var gateNames = map[rune]string{'.': "AND gate", '+': "OR gate", '#': "XOR gate", '^': "NAND gate", '=': "equals gate, 1 when its inputs are the same"}
+
+// describe() - the lines explaining the cell at p of board b at tick ticks
+func describe(b board, p coord, ticks int, delays map[coord]delay, nets *netCache) []string {
+	r := b.getC(p)
+	lines := make([]string, 0)
+	add := func(format string, args ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+	// pin() - a named cell relative to p and what is in it
+	pin := func(name string, dx, dy int) {
+		at := coord{p.x + dx, p.y + dy}
+		add("  %-12s %d %d  %s", name, at.x, at.y, describeRune(b.getC(at)))
+	}
+	x, y := p.x, p.y
+	switch {
+	case r == '*':
+		add("constant input, outputs down, right and up")
+		pin("value", -1, 0)
+	case r == 'C':
+		modulo, fraction := 2, 4
+		if m := b.get(x-1, y); isDigit(m) {
+			if modulo = rune2Int(m); modulo == 0 {
+				modulo = 36
+			}
+			if f := b.get(x-2, y); isDigit(f) {
+				fraction = rune2Int(f)
+			}
+		}
+		add("clock counting 0 to %d every %d ticks", modulo-1, 1<<fraction)
+		pin("modulo", -1, 0)
+		pin("fraction", -2, 0)
+		add("  now          %c", int2Rune((ticks/(1<<fraction))%modulo))
+	case r == 'R':
+		add("random numbers below the maximum")
+		pin("maximum", -1, 0)
+	case r == '-':
+		add("wire, left and right")
+	case r == '|':
+		if b.get(x-1, y) == '-' && b.get(x+1, y) == '-' {
+			add("wire, up and down, crossing under the '-' wire")
+		} else {
+			add("wire, up and down")
+		}
+	case r == '@':
+		add("junction, joins the wires on all four sides")
+	case r == '/' || r == '\\':
+		end, ok := bridgeEnd(b, p)
+		if r == '\\' {
+			end, ok = bridgeStart(b, p)
+		}
+		if ok {
+			add("bridge over the cells between here and %d %d", end.x, end.y)
+		} else {
+			add("bridge with no other end on the row, does nothing")
+		}
+	case r == '~':
+		add("buffer, from the left to the right only, as 0 or 1")
+	case r == '>' || r == '<':
+		add("diode, only passes values which aren't 0, towards the point")
+	case r == 'N':
+		add("inverter, sends 1 for 0 and 0 for anything else on to its other sides")
+	case gateRunes[r]:
+		add(gateNames[r])
+		pin("inTop", 0, -1)
+		pin("inBottom", 0, 1)
+		pin("output", 1, 0)
+		pin("vTopXY", -1, -1)
+		pin("vBottomXY", -1, 1)
+		pin("vOut", -1, 0)
+	case r == 'S' || r == 'Z':
+		kind, below := "normally open relay, on when its control is not 0", 1
+		if r == 'Z' {
+			kind, below = "normally closed relay, off when its control is not 0", -1
+		}
+		add(kind)
+		pin("inControl", 0, -below)
+		pin("inLeft", -1, 0)
+		pin("inRight", 1, 0)
+		pin("vSwitchState", 1, -below)
+		pin("vControl", 0, below)
+		pin("vLeft", -1, below)
+		pin("vRight", 1, below)
+	case r == 'L' || r == 'J':
+		add("lamp, passes the signal left and right")
+		if r == 'L' {
+			pin("value", 0, -1)
+		} else {
+			pin("value", 0, 1)
+		}
+	case r == 'D':
+		add("delay, the input comes out after the lag")
+		pin("inputXY", -1, 0)
+		pin("outputXY", 1, 0)
+		pin("lagXY", 0, -1)
+		pin("oldValueXY", 1, -1)
+		if d, ok := delays[p]; ok {
+			add("  expiration   %d, %d ticks from now", d.expiration, maxInt(0, d.expiration-ticks))
+			add("  lag          %d", d.lag)
+			add("  oldValue     %s", describeRune(d.oldValue))
+			add("  nextValue    %s", describeRune(d.nextValue))
+		} else {
+			add("  not run yet")
+		}
+	case r == 'M':
+		name := make([]rune, 0)
+		for i := x + 1; !nonValue(b.get(i, y)); i++ {
+			name = append(name, b.get(i, y))
+		}
+		add("macro %s from %s, copied in below", string(name), macroFilename(string(name)))
+	case r == 'E':
+		add("exit, stops betula when it gets a value which isn't 0")
+	case r == 'B':
+		add("bell, beeps when it gets a value which isn't 0")
+	case r == '_':
+		add("comment")
+	case isDigit(r):
+		add("value %d", rune2Int(r))
+	case nonValue(r):
+		add("empty")
+	default:
+		add("%c does nothing", r)
+	}
+	if role := roleOf(b, p); role != "" {
+		add("%s", role)
+	}
+
+	if drivers := nets.on[p]; len(drivers) > 0 {
+		add("on the net of %s", componentList(b, drivers))
+	}
+	if arrivals := nets.n.arrivals[p]; len(arrivals) > 0 {
+		from := map[coord]bool{}
+		for _, a := range arrivals {
+			from[a.driver] = true
+		}
+		add("inputs from %s", componentList(b, sortedCoords(from)))
+	}
+	if _, ok := nets.n.wires[p]; ok {
+		if to := nets.reaches(p); len(to) > 0 {
+			add("output reaches %s", componentList(b, to))
+		} else {
+			add("output reaches nothing")
+		}
+	}
+	title := fmt.Sprintf("%d %d %s", x, y, describeRune(r))
+	return append([]string{title}, lines...)
+}
+
+// roleOf() - what the value or empty cell at p is to the component next to it, if anything
+func roleOf(b board, p coord) string {
+	if r := b.getC(p); !isDigit(r) && !nonValue(r) {
+		return ""
+	}
+	x, y := p.x, p.y
+	is := func(dx, dy int, runes string) bool {
+		return strings.ContainsRune(runes, b.get(x+dx, y+dy))
+	}
+	switch {
+	case is(1, 0, "*"):
+		return "the value of the '*' on the right"
+	case is(1, 0, "C"):
+		return "the modulo of the clock on the right"
+	case is(2, 0, "C") && isDigit(b.get(x+1, y)):
+		return "the fraction of the clock two to the right"
+	case is(1, 0, "R"):
+		return "the maximum of the random numbers on the right"
+	case is(0, 1, "D"):
+		return "the lag of the delay below"
+	case is(-1, 1, "D"):
+		return "the old value of the delay below left"
+	case is(0, 1, "L"):
+		return "the value of the lamp below"
+	case is(0, -1, "J"):
+		return "the value of the lamp above"
+	case is(1, 1, ".+#^="):
+		return "the top input value of the gate below right"
+	case is(1, -1, ".+#^="):
+		return "the bottom input value of the gate above right"
+	case is(1, 0, ".+#^="):
+		return "the output value of the gate on the right"
+	case is(-1, 1, "S") || is(-1, -1, "Z"):
+		return "whether the relay is switched on"
+	}
+	return ""
+}
+
+func describeRune(r rune) string {
+	if nonValue(r) {
+		return "empty"
+	}
+	return fmt.Sprintf("'%c'", r)
+}
+
+// componentList() - the components at ps, the first few of them
+func componentList(b board, ps []coord) string {
+	const most = 3
+	names := make([]string, 0, most+1)
+	for i, p := range ps {
+		if i == most {
+			names = append(names, fmt.Sprintf("and %d more", len(ps)-most))
+			break
+		}
+		names = append(names, fmt.Sprintf("'%c' %d %d", b.getC(p), p.x, p.y))
+	}
+	return strings.Join(names, ", ")
+}
+
+// view() - draw the panel in the top corner away from the cell it explains, on pub from m
+func (in *inspector) view(s tcell.Screen, m *machine, pub *publication) {
+	v := theViewport
+	p := in.target()
+	nets := theNets.get(m, pub.edits, pub.b)
+	lines := describe(pub.b, p, pub.ticks, pub.delays, nets)
+	width := 0
+	for _, line := range lines {
+		width = maxInt(width, len([]rune(line))+2)
+	}
+	width = minInt(width, v.width)
+	left := v.width - width
+	if p.x-v.origin.x >= left {
+		left = 0
+	}
+	sty := tcell.StyleDefault.Background(tcell.ColorNavy).Foreground(tcell.ColorWhite)
+	for y, line := range lines {
+		if y >= v.height {
+			break
+		}
+		runes := []rune(" " + line)
+		for x := 0; x < width; x++ {
+			r := ' '
+			if x < len(runes) {
+				r = runes[x]
+			}
+			s.SetContent(left+x, y, r, nil, sty)
+		}
+	}
+}
+
+// commandInspect() - :inspect shows or hides the inspector
+func commandInspect(args []string) error {
+	theInspector.toggle()
+	return nil
+}
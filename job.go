@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Jobs run the commands which can take a long time, like exports after many ticks, away
+// from the event loop so that the screen keeps drawing. Their progress goes on the status
+// line and Esc cancels them. Only one runs at a time.
+
+// job - a command running in the background
+type job struct {
+	name   string
+	cancel chan struct{}
+	shown  time.Time // when the progress was last shown
+}
+
+// theJob - the job running now, nil when there isn't one, guarded by uiMutex
+var theJob *job
+
+// cancelled() - whether Esc was pressed since the job started, work checks it as it goes
+func (j *job) cancelled() bool {
+	select {
+	case <-j.cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+// progress() - say how far the job has got, a few times a second however often it is called
+func (j *job) progress(format string, args ...interface{}) {
+	if time.Since(j.shown) < 200*time.Millisecond {
+		return
+	}
+	j.shown = time.Now()
+	setMiddleMsg(fmt.Sprintf("%s %s - Esc to cancel", j.name, fmt.Sprintf(format, args...)))
+}
+
+// background() - run work away from the event loop, then finish holding uiMutex to show
+// what it made. Called holding uiMutex, from a command.
+func background(name string, work func(j *job) error, finish func() error) error {
+	if theJob != nil {
+		return fmt.Errorf("%s is still running, Esc to cancel it", theJob.name)
+	}
+	j := &job{name: name, cancel: make(chan struct{})}
+	theJob = j
+	j.progress("started")
+	go func() {
+		err := work(j)
+		uiMutex.Lock()
+		defer uiMutex.Unlock()
+		theJob = nil
+		switch {
+		case j.cancelled():
+			setMiddleMsg(fmt.Sprintf("%s cancelled", j.name))
+		case err != nil:
+			setMiddleMsg(err.Error())
+		default:
+			if err := finish(); err != nil {
+				setMiddleMsg(err.Error())
+			}
+		}
+	}()
+	return nil
+}
+
+// cancelJob() - stop the job running now, if there is one. Called holding uiMutex.
+func cancelJob() {
+	if theJob != nil && !theJob.cancelled() {
+		close(theJob.cancel)
+	}
+}
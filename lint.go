@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gdamore/tcell"
+	"os"
+	"strings"
+)
+
+// The linter looks for wiring which is legal but which can't work, as the interpreter
+// ignores it without a word: gates waiting forever for an input, bridges with no end,
+// buffers fed backwards, macros with no file and relays which never switch.
+
+// linter - the problems found by :lint, marked on the board until :lint off
+type linter struct {
+	problems []problem
+}
+
+var theLint = linter{}
+
+// emitsInto() - can the cell at from send a signal into its neighbour to, going by what propagate() does
+func emitsInto(b board, from coord, to coord) bool {
+	d := coord{to.x - from.x, to.y - from.y}
+	across := d.y == 0
+	switch r := b.getC(from); {
+	case r == '-':
+		return across
+	case r == '|':
+		return !across || (b.get(from.x-1, from.y) == '-' && b.get(from.x+1, from.y) == '-')
+	case r == '@' || r == 'N':
+		return true
+	case r == '*' || r == 'C' || r == 'R':
+		return d != coord{-1, 0}
+	case r == '>' || r == '~' || r == '\\' || r == 'D' || gateRunes[r]:
+		return d == coord{1, 0}
+	case r == '<' || r == '/':
+		return d == coord{-1, 0}
+	case r == 'L' || r == 'J' || r == 'S' || r == 'Z':
+		return across
+	}
+	return false
+}
+
+// macroNames() - the macros on b and where their names are, which are not components
+func macroNames(b board) (map[coord]string, map[coord]bool) {
+	macros := map[coord]string{}
+	inName := map[coord]bool{}
+	b.eachOutsideComments(func(p coord, r rune) {
+		if r != 'M' || inName[p] {
+			return
+		}
+		name := make([]rune, 0)
+		for x := p.x + 1; !nonValue(b.get(x, p.y)); x++ {
+			name = append(name, b.get(x, p.y))
+			inName[coord{x, p.y}] = true
+		}
+		if len(name) > 0 {
+			macros[p] = string(name)
+		}
+	})
+	return macros, inName
+}
+
+// lint() - the wiring on b which fails silently when it runs, top to bottom and left to right
+func lint(b board) []problem {
+	problems := make([]problem, 0)
+	report := func(p coord, format string, args ...interface{}) {
+		problems = append(problems, problem{p, fmt.Sprintf(format, args...)})
+	}
+	macros, inName := macroNames(b)
+	b.eachOutsideComments(func(p coord, r rune) {
+		if inName[p] {
+			return
+		}
+		left, right, top, bottom := coord{p.x - 1, p.y}, coord{p.x + 1, p.y}, coord{p.x, p.y - 1}, coord{p.x, p.y + 1}
+		switch {
+		case gateRunes[r]:
+			wiredTop, wiredBottom := emitsInto(b, top, p), emitsInto(b, bottom, p)
+			if wiredTop && !wiredBottom {
+				report(p, "'%c' has only its top input wired and waits forever for the bottom one", r)
+			} else if wiredBottom && !wiredTop {
+				report(p, "'%c' has only its bottom input wired and waits forever for the top one", r)
+			}
+		case r == '/':
+			if _, ok := bridgeEnd(b, p); !ok {
+				report(p, "'/' has no '\\' after it on the row, so the bridge goes nowhere")
+			}
+		case r == '\\':
+			if _, ok := bridgeStart(b, p); !ok {
+				report(p, "'\\' has no '/' before it on the row, so the bridge goes nowhere")
+			}
+		case r == '~':
+			if emitsInto(b, left, p) {
+				break
+			}
+			for _, side := range []struct {
+				at   coord
+				name string
+			}{{right, "right"}, {top, "top"}, {bottom, "bottom"}} {
+				if emitsInto(b, side.at, p) {
+					report(p, "'~' is fed from the %s but only passes signals from left to right", side.name)
+					break
+				}
+			}
+		case r == 'M':
+			if name, ok := macros[p]; ok {
+				if _, err := os.Stat(macroFilename(name)); err != nil {
+					report(p, "there is no file %s for the macro %s", macroFilename(name), name)
+				}
+			}
+		case r == 'S' || r == 'Z':
+			control := top // S is switched from above, Z from below
+			if r == 'Z' {
+				control = bottom
+			}
+			if !emitsInto(b, control, p) {
+				report(p, "'%c' has nothing wired to its control at %d %d, so it never switches", r, control.x, control.y)
+			}
+		}
+	})
+	return problems
+}
+
+// mark() - mark the cells with problems
+func (li *linter) mark(m marks) {
+	for _, pr := range li.problems {
+		m[pr.at] = tcell.ColorDarkOrange
+	}
+}
+
+// commandLint() - :lint lists the problems on the board and marks them, :lint off clears the marks
+func commandLint(args []string) error {
+	if len(args) == 1 && args[0] == "off" {
+		theLint.problems = nil
+		return nil
+	}
+	if len(args) != 0 {
+		return fmt.Errorf("usage: lint [off]")
+	}
+	snapshot(func(b board, _ int) {
+		theLint.problems = lint(b)
+	})
+	if len(theLint.problems) == 0 {
+		setMiddleMsg("No problems found")
+		return nil
+	}
+	theCommandLine.help = problemLines(fmt.Sprintf("%d problems, marked until :lint off", len(theLint.problems)), theLint.problems)
+	return nil
+}
+
+// lintMain() - betula lint file.betula, listing the problems and failing if there are any
+func lintMain(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: betula lint file.betula")
+	}
+	headlessInit()
+	b, _, err := loadFile(args[0])
+	if err != nil {
+		return err
+	}
+	problems := lint(b)
+	lines := make([]string, 0, len(problems))
+	for _, p := range problems {
+		lines = append(lines, fmt.Sprintf("%s: %s\n", args[0], p))
+	}
+	if _, err := os.Stdout.WriteString(strings.Join(lines, "")); err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d problems in %s", len(problems), args[0])
+	}
+	return nil
+}
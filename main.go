@@ -2,26 +2,38 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"flag"
 	"fmt"
 	"github.com/gdamore/tcell"
 	"io"
+	"math"
 	"math/rand"
 	"sync"
 	"time"
 
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
-type board [][]rune
+// board - an unbounded sparse grid of cells, stored as square chunks which are
+// allocated on demand as cells are written. Cells never written read as ' '.
+type board map[coord]*chunk
+
+const chunkSize = 16
+
+type chunk [chunkSize][chunkSize]rune
 type visitors map[coord]int
 
-var width, height int
 var cursorX int
 var cursorY int
 
-var boardMutex sync.RWMutex
+// uiMutex - held by the event loop while it handles an event and by render() while it paints,
+// as both use the cursor, the viewport, the editor, the buffers and the other state of the user interface
+var uiMutex sync.Mutex
+
 var noValues = map[rune]bool{' ': true, 0: true}
 var zeroValues = map[rune]bool{' ': true, '0': true, 0: true}
 
@@ -54,7 +66,6 @@ func toBinary(x rune) rune {
 type coord struct{ x, y int }
 
 var nowhere = coord{-1, -1}
-var clockTicks int
 
 type delay struct {
 	expiration int
@@ -68,9 +79,7 @@ type delay struct {
 	lag        int
 }
 
-var allDelays = map[coord]*delay{}
-
-func makeDelay(p coord, value rune, b board) *delay {
+func makeDelay(p coord, value rune, b board, now int) *delay {
 	var d = delay{}
 
 	d.selfXY = p
@@ -85,10 +94,10 @@ func makeDelay(p coord, value rune, b board) *delay {
 		d.lag = 0
 		b.setC(d.lagXY, int2Rune(0))
 	}
-	d.expiration = clockTicks + d.lag
+	d.expiration = now + d.lag
 	return &d
 }
-func (d *delay) reset(b board, value rune) {
+func (d *delay) reset(b board, value rune, now int) {
 	// delay is over - use the new value
 	d.oldValue = value
 	d.nextValue = 0
@@ -98,10 +107,10 @@ func (d *delay) reset(b board, value rune) {
 		d.lag = 0
 		b.setC(d.lagXY, int2Rune(0))
 	}
-	d.expiration = clockTicks + d.lag
+	d.expiration = now + d.lag
 }
 
-func (d *delay) propagate(visited visitors, b board, f coord, value rune, multi map[coord]int) {
+func (d *delay) propagate(m *machine, visited visitors, b board, f coord, value rune, multi map[coord]int) {
 	if f == d.inputXY {
 		d.nextValue = value
 	}
@@ -109,14 +118,14 @@ func (d *delay) propagate(visited visitors, b board, f coord, value rune, multi
 		return
 	}
 	visited.done(d.selfXY)
-	if d.expiration > clockTicks { // delay is not over
-		propagate(visited, b, d.selfXY, d.outputXY, d.oldValue, multi)
+	if d.expiration > m.clockTicks { // delay is not over
+		m.propagate(visited, b, d.selfXY, d.outputXY, d.oldValue, multi)
 		return
 	}
 	// delay is over, reset if we have a new value
 	if d.nextValue != 0 {
-		propagate(visited, b, d.selfXY, d.outputXY, d.oldValue, multi)
-		d.reset(b, d.nextValue)
+		m.propagate(visited, b, d.selfXY, d.outputXY, d.oldValue, multi)
+		d.reset(b, d.nextValue, m.clockTicks)
 	}
 }
 
@@ -132,7 +141,7 @@ type relay struct {
 	switchONfn func(rune) bool
 }
 
-func (r *relay) propagate(visited visitors, b board, f coord, p coord, value rune, multi map[coord]int) {
+func (r *relay) propagate(m *machine, visited visitors, b board, f coord, p coord, value rune, multi map[coord]int) {
 	// ignore if not the three inputs
 	if !(f == r.inLeft || f == r.inRight || f == r.inControl || f == nowhere) {
 		return
@@ -187,11 +196,11 @@ func (r *relay) propagate(visited visitors, b board, f coord, p coord, value run
 	if b.getC(r.vLeft) != ' ' {
 		// left
 		visited.done(p)
-		propagate(visited, b, p, coord{p.x + 1, p.y}, b.getC(r.vLeft), multi)
+		m.propagate(visited, b, p, coord{p.x + 1, p.y}, b.getC(r.vLeft), multi)
 	} else if b.getC(r.vRight) != ' '  {
 		// from right
 		visited.done(p)
-		propagate(visited, b, p, coord{p.x - 1, p.y}, b.getC(r.vRight), multi)
+		m.propagate(visited, b, p, coord{p.x - 1, p.y}, b.getC(r.vRight), multi)
 	}
 }
 
@@ -209,13 +218,14 @@ type wire struct {
 	outputs []coord
 }
 
-func (w *wire) propagate(visited visitors, b board, p coord, value rune, multi map[coord]int) {
+func (w *wire) propagate(m *machine, visited visitors, b board, p coord, value rune, multi map[coord]int) {
 	if visited.yes(p) {
 		return
 	}
 	visited.done(p)
+	m.carry(p, value)
 	for _, out := range w.outputs {
-		propagate(visited, b, p, out, value, multi)
+		m.propagate(visited, b, p, out, value, multi)
 	}
 }
 
@@ -223,22 +233,20 @@ type diode struct {
 	output coord
 }
 
-func (d *diode) propagate(visited visitors, b board, p coord, value rune, multi map[coord]int) {
+func (d *diode) propagate(m *machine, visited visitors, b board, p coord, value rune, multi map[coord]int) {
 	if visited.yes(p) {
 		return
 	}
 	visited.done(p)
+	m.carry(p, value)
 	if !isZero(value) {
-		propagate(visited, b, p, d.output, value, multi)
+		m.propagate(visited, b, p, d.output, value, multi)
 	}
 }
 
-func propagate(visited visitors, b board, f coord, p coord, value rune, multi map[coord]int) {
+func (m *machine) propagate(visited visitors, b board, f coord, p coord, value rune, multi map[coord]int) {
 
-	if b.off(p.x, p.y) {
-		return
-	}
-	if len(visited) > 1 && nonValue(b[p.x][p.y]) {
+	if len(visited) > 1 && nonValue(b.getC(p)) {
 		return
 	}
 
@@ -255,7 +263,7 @@ func propagate(visited visitors, b board, f coord, p coord, value rune, multi ma
 		visited.done(p)
 		constant := b.get(p.x-1, p.y)
 		for _, out := range outputs {
-			propagate(visited, b, p, out, constant, multi)
+			m.propagate(visited, b, p, out, constant, multi)
 		}
 
 	case 'R':
@@ -276,9 +284,9 @@ func propagate(visited visitors, b board, f coord, p coord, value rune, multi ma
 				maxrand = 1
 			}
 		}
-		randi := int2Rune(rand.Intn(maxrand))
+		randi := int2Rune(m.random.Intn(maxrand))
 		for _, out := range outputs {
-			propagate(visited, b, p, out, randi, multi)
+			m.propagate(visited, b, p, out, randi, multi)
 		}
 
 	case 'C':
@@ -306,16 +314,16 @@ func propagate(visited visitors, b board, f coord, p coord, value rune, multi ma
 				div = 1 << fraction
 			}
 		}
-		clock := (clockTicks / div) % modulo
+		clock := (m.clockTicks / div) % modulo
 		clockRune := int2Rune(clock)
 		visited.done(p)
 		for _, out := range outputs {
-			propagate(visited, b, p, out, clockRune, multi)
+			m.propagate(visited, b, p, out, clockRune, multi)
 		}
 
 	case '-':
 		leftRight := wire{[]coord{{p.x+1, p.y}, {p.x - 1, p.y}}}
-		leftRight.propagate(visited, b, p, value, multi)
+		leftRight.propagate(m, visited, b, p, value, multi)
 
 	case '|':
 		//         .
@@ -331,15 +339,16 @@ func propagate(visited visitors, b board, f coord, p coord, value rune, multi ma
 			//
 			// if signal is left<>right then pass through horizontally
 			leftRightUnder := wire{[]coord{right, left}}
+			m.carry(p, value)
 			for _, out := range leftRightUnder.outputs {
-				propagate(visited, b, p, out, value, multi)
+				m.propagate(visited, b, p, out, value, multi)
 			}
 			return
 		}
 		if f == up || f == down {
 			// normal top<>bottom
 			upDown := wire{[]coord{{p.x, p.y - 1}, {p.x, p.y + 1}}}
-			upDown.propagate(visited, b, p, value, multi)
+			upDown.propagate(m, visited, b, p, value, multi)
 		}
 
 	case '/':
@@ -347,13 +356,16 @@ func propagate(visited visitors, b board, f coord, p coord, value rune, multi ma
 		//      /     \
 		//
 		var end int
+		found := false
 		// Find the end
-		for end = p.x + 1; end < width-2; end++ {
+		right := m.bounds.bottomRight.x
+		for end = p.x + 1; end <= right; end++ {
 			if b.get(end, p.y) == '\\' {
+				found = true
 				break
 			}
 		}
-		if end == 0 { // no end so do nothing
+		if !found { // no end so do nothing
 			return
 		}
 		if visited.yes(p) || visited.yes(coord{end, p.y}) {
@@ -361,21 +373,26 @@ func propagate(visited visitors, b board, f coord, p coord, value rune, multi ma
 		}
 		visited.done(p)
 		visited.done(coord{end, p.y})
-		propagate(visited, b, p, coord{end + 1, p.y}, value, multi)
-		propagate(visited, b, p, coord{p.x - 1, p.y}, value, multi)
+		m.carry(p, value)
+		m.carry(coord{end, p.y}, value)
+		m.propagate(visited, b, p, coord{end + 1, p.y}, value, multi)
+		m.propagate(visited, b, p, coord{p.x - 1, p.y}, value, multi)
 
 	case '\\':
 		//
 		//      /     \
 		//
 		var begin int
+		found := false
 		// find the start
-		for begin = p.x - 1; begin > 0; begin-- {
+		left := m.bounds.topLeft.x
+		for begin = p.x - 1; begin >= left; begin-- {
 			if b.get(begin, p.y) == '/' {
+				found = true
 				break
 			}
 		}
-		if begin == 0 { // no start so nothing to do
+		if !found { // no start so nothing to do
 			return
 		}
 		if visited.yes(p) || visited.yes(coord{begin, p.y}) {
@@ -383,8 +400,10 @@ func propagate(visited visitors, b board, f coord, p coord, value rune, multi ma
 		}
 		visited.done(p)
 		visited.done(coord{begin, p.y})
-		propagate(visited, b, p, coord{begin - 1, p.y}, value, multi)
-		propagate(visited, b, p, coord{p.x - 1, p.y}, value, multi)
+		m.carry(coord{begin, p.y}, value)
+		m.carry(p, value)
+		m.propagate(visited, b, p, coord{begin - 1, p.y}, value, multi)
+		m.propagate(visited, b, p, coord{p.x - 1, p.y}, value, multi)
 
 	case '@':
 		blob := wire{[]coord{
@@ -393,7 +412,7 @@ func propagate(visited visitors, b board, f coord, p coord, value rune, multi ma
 			{p.x + 1, p.y},
 			{p.x - 1, p.y},
 		}}
-		blob.propagate(visited, b, p, value, multi)
+		blob.propagate(m, visited, b, p, value, multi)
 
 	case '~':
 		// Buffer left->right
@@ -402,17 +421,18 @@ func propagate(visited visitors, b board, f coord, p coord, value rune, multi ma
 			return
 		}
 		visited.done(p)
-		propagate(visited, b, p, output, toBinary(value), multi)
+		m.carry(p, value)
+		m.propagate(visited, b, p, output, toBinary(value), multi)
 
 	case '>':
 		// Diode
 		lrdiode := diode{coord{p.x + 1, p.y}}
-		lrdiode.propagate(visited, b, p, value, multi)
+		lrdiode.propagate(m, visited, b, p, value, multi)
 
 	case '<':
 		// Diode
 		rldiode := diode{coord{p.x - 1, p.y}}
-		rldiode.propagate(visited, b, p, value, multi)
+		rldiode.propagate(m, visited, b, p, value, multi)
 
 	case 'E':
 		// Exit
@@ -420,6 +440,9 @@ func propagate(visited visitors, b board, f coord, p coord, value rune, multi ma
 			return
 		}
 		visited.done(p)
+		if m.headless {
+			return
+		}
 		if nonValue(value) || !isZero(value) {
 			comment := b.getComment(coord{p.x + 1, p.y})
 			_, _ = fmt.Fprintf(os.Stderr, "E cell exit at location %d %d. Expected '0', got '%c' (%d) - message: '%s'\n", p.x, p.y, value, rune2Int(value), comment)
@@ -432,7 +455,7 @@ func propagate(visited visitors, b board, f coord, p coord, value rune, multi ma
 			return
 		}
 		visited.done(p)
-		if !isZero(value) {
+		if !isZero(value) && !m.headless {
 			beep()
 		}
 
@@ -445,7 +468,7 @@ func propagate(visited visitors, b board, f coord, p coord, value rune, multi ma
 			{p.x - 1, p.y},
 		}}
 		inverted := cond(value, '0', '1')
-		inverter.propagate(visited, b, p, inverted, multi)
+		inverter.propagate(m, visited, b, p, inverted, multi)
 
 	case 'S':
 		// Normally Open Relay Switch
@@ -463,7 +486,7 @@ func propagate(visited visitors, b board, f coord, p coord, value rune, multi ma
 		r.inRight = coord{p.x+1, p.y}
 		r.defaultState = '0' // OFF Normally Open (NO)
 		r.switchONfn = func (r rune) bool { return !isZero(r) }
-		r.propagate(visited, b, f, p, value, multi)
+		r.propagate(m, visited, b, f, p, value, multi)
 
 	case 'Z':
 		// Normally Closed Relay Switch
@@ -481,7 +504,7 @@ func propagate(visited visitors, b board, f coord, p coord, value rune, multi ma
 		r.inRight = coord{p.x+1, p.y}
 		r.defaultState = '1' // ON Normally Closed (NC)
 		r.switchONfn = isZero
-		r.propagate(visited, b, f, p, value, multi)
+		r.propagate(m, visited, b, f, p, value, multi)
 
 	case 'L':
 		// Lamp on top of wire
@@ -490,7 +513,7 @@ func propagate(visited visitors, b board, f coord, p coord, value rune, multi ma
 		//
 		topLamp := wire{[]coord{{p.x+1, p.y}, {p.x - 1, p.y}}}
 		b.set(p.x, p.y-1, value)
-		topLamp.propagate(visited, b, p, value, multi)
+		topLamp.propagate(m, visited, b, p, value, multi)
 
 	case 'J':
 		// Lamp underneath wire
@@ -500,20 +523,20 @@ func propagate(visited visitors, b board, f coord, p coord, value rune, multi ma
 		//
 		bottomLamp := wire{[]coord{{p.x+1, p.y}, {p.x - 1, p.y}}}
 		b.set(p.x, p.y+1, value)
-		bottomLamp.propagate(visited, b, p, value, multi)
+		bottomLamp.propagate(m, visited, b, p, value, multi)
 
 	case 'D':
 		// delay
 		//      ...
 		//		.D.
 		//
-		del, ok := allDelays[p]
+		del, ok := m.delays[p]
 		if !ok {
 			// need a new backing object
-			del = makeDelay(p, value, b)
-			allDelays[p] = del
+			del = makeDelay(p, value, b, m.clockTicks)
+			m.delays[p] = del
 		}
-		del.propagate(visited, b, f, value, multi)
+		del.propagate(m, visited, b, f, value, multi)
 
 	case '=':
 		//       ..
@@ -523,19 +546,19 @@ func propagate(visited visitors, b board, f coord, p coord, value rune, multi ma
 		equals := func(A, B rune) bool {
 			return A == B
 		}
-		runeGate(visited, b, f, p, value, equals, multi)
+		runeGate(m, visited, b, f, p, value, equals, multi)
 	case '.':
 		and := func(A, B bool) bool { return A && B }
-		logicGate(visited, b, f, p, value, and, multi)
+		logicGate(m, visited, b, f, p, value, and, multi)
 	case '+':
 		or := func(A, B bool) bool { return A || B }
-		logicGate(visited, b, f, p, value, or, multi)
+		logicGate(m, visited, b, f, p, value, or, multi)
 	case '#':
 		exclusiveOr := func(A, B bool) bool { return A != B }
-		logicGate(visited, b, f, p, value, exclusiveOr, multi)
+		logicGate(m, visited, b, f, p, value, exclusiveOr, multi)
 	case '^':
 		nand := func(A, B bool) bool { return !(A && B) }
-		logicGate(visited, b, f, p, value, nand, multi)
+		logicGate(m, visited, b, f, p, value, nand, multi)
 	default:
 	}
 }
@@ -579,7 +602,7 @@ type gate struct {
 	condition func(bool, bool) bool
 }
 
-func runeGate(visited visitors, b board, f coord, p coord, value rune, conditionFn func(rune, rune) bool, multi map[coord]int) {
+func runeGate(m *machine, visited visitors, b board, f coord, p coord, value rune, conditionFn func(rune, rune) bool, multi map[coord]int) {
 	//
 	//    ..
 	//    .X
@@ -594,10 +617,10 @@ func runeGate(visited visitors, b board, f coord, p coord, value rune, condition
 	g.vOut = coord{p.x - 1, p.y}
 	g.output = coord{p.x + 1, p.y}
 	g.runeCondition = conditionFn
-	g.propagate(visited, b, f, p, value, multi)
+	g.propagate(m, visited, b, f, p, value, multi)
 }
 
-func logicGate(visited visitors, b board, f coord, p coord, value rune, conditionFn func(bool, bool) bool, multi map[coord]int) {
+func logicGate(m *machine, visited visitors, b board, f coord, p coord, value rune, conditionFn func(bool, bool) bool, multi map[coord]int) {
 	//
 	//    ..
 	//    .X
@@ -612,9 +635,9 @@ func logicGate(visited visitors, b board, f coord, p coord, value rune, conditio
 	g.vOut = coord{p.x - 1, p.y}
 	g.output = coord{p.x + 1, p.y}
 	g.condition = conditionFn
-	g.propagate(visited, b, f, p, value, multi)
+	g.propagate(m, visited, b, f, p, value, multi)
 }
-func (g *gate) propagate(visited visitors, b board, f coord, p coord, value rune, multi map[coord]int) {
+func (g *gate) propagate(m *machine, visited visitors, b board, f coord, p coord, value rune, multi map[coord]int) {
 
 	// ignore if not the two inputs
 	if !(f == g.inTop || f == g.inBottom || f == nowhere) {
@@ -662,117 +685,296 @@ func (g *gate) propagate(visited visitors, b board, f coord, p coord, value rune
 		}
 	}
 	b.setC(g.vOut, outputValue)
-	propagate(visited, b, p, g.output, outputValue, multi)
+	m.propagate(visited, b, p, g.output, outputValue, multi)
 }
 
-var macros = make(map[string]board)
-
-func expandMacro(pb board, home coord, name string) {
-	mb, ok := macros[name]
+func (m *machine) expandMacro(pb board, home coord, name string) {
+	mb, ok := m.macros[name]
 	if !ok {
-		macroBoard, err := loadMacroFile(fmt.Sprintf("%s.betula", name))
+		macroBoard, err := loadMacroFile(macroFilename(name))
 		if err != nil {
 			setMiddleMsg(err.Error())
 			return
 		}
-		macros[name] = macroBoard
+		m.macros[name] = macroBoard
 		mb = macroBoard
 	}
-	parentWidth := len(pb)
-	parentHeight := len(pb[0])
-	macroWidth := len(mb)
-	macroHeight := len(mb[0])
-	for x := 0; x < macroWidth; x++ {
-		for y := 0; y < macroHeight; y++ {
-			if home.x+x >= parentWidth || home.y+y >= parentHeight {
-				continue
-			}
-			if nonValue(mb[x][y]) {
-				continue
-			}
-			pb.set(home.x+x, home.y+y, mb[x][y])
+	mb.each(func(p coord, r rune) {
+		if nonValue(r) {
+			return
 		}
+		pb.set(home.x+p.x, home.y+p.y, r)
+	})
+}
+
+// edit - a change to the board requested by the user interface.
+// Edits are queued and applied by the interpreter between ticks, so the
+// interpreter is the only goroutine that ever writes to the working board.
+type edit func(b board)
+
+// publication - a copy of the working board at the end of a tick and what was noticed about
+// it. It is never changed once published, so readers may keep it as long as they like.
+type publication struct {
+	b        board
+	ticks    int
+	warnings warnings        // about the tick
+	delays   map[coord]delay // copies of the delays
+	edits    int             // edits applied to the board
+	carried  carried         // the values on the wires in the tick
+}
+
+// machine - an interpreter running over one board, with its own clock, delays and macros.
+// The interpreter publishes a new copy of the working board at the end of each tick.
+// The lock is only held to swap the front publication for the new one, never while
+// the copy is made or while a reader looks at it.
+type machine struct {
+	b             board // the working board, only used by the interpreter goroutine
+	front         *publication
+	mutex         sync.RWMutex
+	edits         chan edit
+	done          chan struct{}
+	clockTicks    int
+	clockSpeed    time.Duration
+	delays        map[coord]*delay
+	applied       int
+	macros        map[string]board
+	recording     *recording // frames captured as the board runs, nil when not recording
+	warnings      warnings   // about the last tick
+	history       []state    // the last few ticks, to find oscillations
+	carried       carried    // the values on the wires in this tick
+	bounds        rectangle  // of the board in this tick, worked out once as bounds() walks every chunk
+	headless      bool       // run away from the screen, so 'E' and 'B' do nothing
+	random        *rand.Rand // for the 'R' cells, so a seed only changes the numbers of this board
+	seed          int64      // of random, when seeded is set
+	seeded        bool
+}
+
+// makeMachine() - a machine over board b which is not running
+func makeMachine(b board) *machine {
+	return &machine{
+		b:          b,
+		front:      &publication{b: makeBoard()},
+		edits:      make(chan edit, 1024),
+		done:       make(chan struct{}),
+		clockSpeed: *clockSpeed,
+		delays:     map[coord]*delay{},
+		macros:     make(map[string]board),
+		random:     rand.New(rand.NewSource(rand.Int63())),
+	}
+}
+
+// newMachine() - publish the initial board b and run an interpreter over it with settings s
+func newMachine(b board, s settings) *machine {
+	m := makeMachine(b)
+	s.apply(m)
+	m.publish()
+	go m.interpreter()
+	return m
+}
+
+// reseed() - start the random numbers of m again from n, remembering n to save
+func (m *machine) reseed(n int64) {
+	m.random.Seed(n)
+	m.seed, m.seeded = n, true
+}
+
+// headlessInit() - the status line and the bell for a command run without the editor, which has neither
+func headlessInit() {
+	setMiddleMsg = func(string) {}
+	setLeftMsg = func(string) {}
+	beep = func() {}
+}
+
+// headlessMachine() - a machine over board b for running a copy away from the screen
+func headlessMachine(b board) *machine {
+	m := makeMachine(b)
+	m.headless = true
+	return m
+}
+
+// step() - one tick of the clock
+func (m *machine) step() {
+	m.clockTicks += 1
+	m.tick(m.b)
+	if m.recording != nil {
+		m.recording.capture(m.b, m.clockTicks, m.clockSpeed)
+	}
+}
+
+// run() - tick the working board n times without an interpreter goroutine
+func (m *machine) run(n int) {
+	for i := 0; i < n; i++ {
+		m.step()
+	}
+}
+
+// submit() - queue an edit for the interpreter
+func (m *machine) submit(e edit) {
+	if e == nil {
+		return
+	}
+	m.edits <- e
+}
+
+// publish() - make a copy of the working board for the readers. A new board each time, as
+// a reader may still be looking at the last one.
+func (m *machine) publish() {
+	p := &publication{b: makeBoard(), ticks: m.clockTicks, warnings: m.warnings, edits: m.applied, carried: m.carried}
+	m.b.copyInto(p.b)
+	p.delays = make(map[coord]delay, len(m.delays))
+	for at, d := range m.delays {
+		p.delays[at] = *d
 	}
+	m.mutex.Lock()
+	m.front = p
+	m.mutex.Unlock()
+}
+
+// latest() - the last publication
+func (m *machine) latest() *publication {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.front
+}
 
+// snapshot() - call fn with the latest published board and its clock tick.
+// fn must not modify the board.
+func (m *machine) snapshot(fn func(b board, ticks int)) {
+	p := m.latest()
+	fn(p.b, p.ticks)
 }
-func interpreter(b board) {
+
+// stop() - end the interpreter
+func (m *machine) stop() {
+	close(m.done)
+}
+
+// applyEdits() - apply the queued edits until the next tick is due,
+// returns false when the interpreter has been stopped
+func (m *machine) applyEdits(next <-chan time.Time) bool {
 	for {
-		clockTicks += 1
-		boardMutex.Lock()
-		roots := make([]coord, 0)
-		// Find and copy Macros # TODO recursive...
-		for y := 0; y < height-1; y++ {
-			for x := 0; x < width; x++ {
-				switch b[x][y] {
-				case 'M':
-					// collect the name
-					name := make([]rune, width)
-					var i int
-					for i = 0; ; i++ {
-						if x+i >= width {
-							break
-						}
-						if nonValue(b[x+1+i][y]) {
-							break
-						}
-						name[i] = b[x+1+i][y]
-					}
-					name = name[:i]
-					if len(name) == 0 {
-						break
-					}
-					expandMacro(b, coord{x, y + 1}, string(name))
+		select {
+		case <-m.done:
+			return false
+		case e := <-m.edits:
+			e(m.b)
+			m.applied++
+			m.history = nil // a new start for finding oscillations
+			// apply everything else already queued before publishing
+			for pending := true; pending; {
+				select {
+				case e := <-m.edits:
+					e(m.b)
+					m.applied++
+				default:
+					pending = false
 				}
 			}
+			m.publish()
+		case <-next:
+			return true
 		}
-		// Find comments, roots and reset indicators
-		for y := 0; y < height-1; y++ {
-			for x := 0; x < width; x++ {
-				switch b.get(x, y) {
-				case '_':
-					x = b.findCommentEnd(x+1, y) + 1
-				case 'L':
-					b.set(x, y-1, ' ')
-				case 'J':
-					b.set(x, y+1, ' ')
-				case '*':
-					roots = append(roots, coord{x, y})
-				case 'C':
-					roots = append(roots, coord{x, y})
-				case 'R':
-					roots = append(roots, coord{x, y})
-				case 'D':
-					roots = append(roots, coord{x, y})
-				default:
+	}
+}
+
+func (m *machine) interpreter() {
+	for running := true; running; {
+		m.step()
+		m.publish()
+		running = m.applyEdits(time.After(m.clockSpeed))
+	}
+}
+
+// submit() - queue an edit from the editor for the interpreter of the current buffer
+func submit(e edit) {
+	if e == nil {
+		return
+	}
+	bu := current()
+	bu.changed()
+	bu.m.submit(e)
+}
+
+// snapshot() - call fn with the latest published board of the current buffer
+func snapshot(fn func(b board, ticks int)) {
+	current().m.snapshot(fn)
+}
+
+// expandMacros() - copy the macro named after each 'M' cell under it # TODO recursive...
+func (m *machine) expandMacros(b board) {
+	r := b.bounds()
+	for y := r.topLeft.y; y <= r.bottomRight.y; y++ {
+		for x := r.topLeft.x; x <= r.bottomRight.x; x++ {
+			switch b.get(x, y) {
+			case 'M':
+				// collect the name
+				name := make([]rune, 0)
+				for i := x + 1; !nonValue(b.get(i, y)); i++ {
+					name = append(name, b.get(i, y))
 				}
+				if len(name) == 0 {
+					break
+				}
+				m.expandMacro(b, coord{x, y + 1}, string(name))
 			}
 		}
-		multiPass := make(visitors)
+	}
+}
 
-		for pass := 1;  ; pass++ {
-			for _, p := range roots {
-				visited := make(visitors)
-				propagate(visited, b, nowhere, p, ' ', multiPass)
-			}
-			if len(multiPass) == 0 || pass > 4 {
-				break
-			}
-			for p := range multiPass {
-				visited := make(map[coord]int)
-				propagate(visited, b, nowhere, p, ' ', multiPass)
+func (m *machine) tick(b board) {
+	roots := make([]coord, 0)
+	varying := false // clocks and random numbers change the board by themselves
+	m.expandMacros(b)
+	m.carried = carried{}
+	// Find comments, roots and reset indicators
+	m.bounds = b.bounds()
+	r := m.bounds
+	for y := r.topLeft.y; y <= r.bottomRight.y; y++ {
+		for x := r.topLeft.x; x <= r.bottomRight.x; x++ {
+			switch b.get(x, y) {
+			case '_':
+				x = b.commentEnd(x+1, y, r.bottomRight.x) + 1
+			case 'L':
+				b.set(x, y-1, ' ')
+			case 'J':
+				b.set(x, y+1, ' ')
+			case '*':
+				roots = append(roots, coord{x, y})
+			case 'C':
+				roots = append(roots, coord{x, y})
+				varying = true
+			case 'R':
+				roots = append(roots, coord{x, y})
+				varying = true
+			case 'D':
+				roots = append(roots, coord{x, y})
+			default:
 			}
 		}
-		boardMutex.Unlock()
-		time.Sleep(*clockSpeed)
 	}
+	multiPass := make(visitors)
+
+	for pass := 1;  ; pass++ {
+		for _, p := range roots {
+			visited := make(visitors)
+			m.propagate(visited, b, nowhere, p, ' ', multiPass)
+		}
+		if len(multiPass) == 0 || pass > 4 {
+			break
+		}
+		for p := range multiPass {
+			visited := make(map[coord]int)
+			m.propagate(visited, b, nowhere, p, ' ', multiPass)
+		}
+	}
+	m.warnings.unsettled = unsettledAt(b, multiPass)
+	m.watch(b, varying)
 }
-func render(s tcell.Screen, b board) {
+func render(s tcell.Screen) {
 	for {
-		boardMutex.Lock()
-		val := b.get(cursorX, cursorY)
-		setLeftMsg(fmt.Sprintf("%d %3d %3d %c %2d", clockTicks, cursorX, cursorY, val, rune2Int(val)))
-		boardMutex.Unlock()
-		view(s, b)
+		uiMutex.Lock()
+		view(s)
+		uiMutex.Unlock()
 		s.Show()
 		time.Sleep(*renderTime)
 	}
@@ -791,163 +993,382 @@ func maxInt(x, x2 int) int {
 	return x
 }
 
-func sizeOfFile(filename string) (int, int, error) {
+// macroFilename() - the file the macro name is loaded from
+func macroFilename(name string) string {
+	return fmt.Sprintf("%s.betula", name)
+}
+
+func loadMacroFile(filename string) (board, error) {
+	b, _, err := loadFile(filename)
+	return b, err
+}
+
+// layout - the shape of the file a board was loaded from, so that saving it again
+// writes the same bytes, and the settings from its sidecar. The zero layout is for a new file.
+type layout struct {
+	widths       []int // the runes on each line, not counting the newline
+	unterminated bool  // the last line has no newline
+	settings     settings
+}
+
+// rectangular() - are all the lines the same width, as when the editor saved the file
+func (l layout) rectangular() bool {
+	for _, w := range l.widths {
+		if w != l.widths[0] {
+			return false
+		}
+	}
+	return true
+}
+
+func loadFile(filename string) (board, layout, error) {
 	fd, err := os.Open(filename)
 	defer func(fd *os.File) { _ = fd.Close() }(fd)
 	if err != nil {
-		return 0, 0, err
+		return nil, layout{}, err
 	}
 	rdr := bufio.NewReader(fd)
-	height := 1
-	width := 0
+	y := 0
 	x := 0
+	width := 0
+	b := makeBoard()
+	l := layout{widths: make([]int, 0)}
 
 	for {
 		r, _, err := rdr.ReadRune()
 		if err == io.EOF {
-			return width, height, nil
+			if x > 0 {
+				l.widths = append(l.widths, x)
+				l.unterminated = true
+			}
+			if l.settings, err = loadSettings(filename); err != nil {
+				return nil, layout{}, err
+			}
+			setMiddleMsg(fmt.Sprintf("Loaded %s, width %d, height %d", filename, width, len(l.widths)))
+			return b, l, nil
 		}
 		if err != nil {
-			return 0, 0, err
+			return nil, layout{}, err
 		}
 		if r == '\n' {
-			width = maxInt(width, x)
+			l.widths = append(l.widths, x)
 			x = 0
-			height += 1
+			y += 1
 			continue
 		}
+		b.set(x, y, r)
 		x += 1
+		width = maxInt(width, x)
 	}
 }
-func loadMacroFile(filename string) (board, error) {
-	macroWidth, macroHeight, err := sizeOfFile(filename)
+
+// text() - the board as the lines of a file shaped like l. Each line is as long as it was
+// in l, or as long as it needs to be for its cells. A rectangular layout stays rectangular.
+func (b board) text(l layout) []byte {
+	r := b.drawnExtent() // not what running the board wrote outside it
+	// always save from the origin, and anything written above or left of it
+	left, top := minInt(r.topLeft.x, 0), minInt(r.topLeft.y, 0)
+	bottom := maxInt(r.bottomRight.y, len(l.widths)-1)
+
+	// lineEnd() - one past the last x to write on line y
+	lineEnd := func(y int) int {
+		if l.rectangular() {
+			if len(l.widths) > 0 {
+				return maxInt(l.widths[0], r.bottomRight.x+1)
+			}
+			return r.bottomRight.x + 1
+		}
+		end := 0
+		if y >= 0 && y < len(l.widths) {
+			end = l.widths[y]
+		}
+		for x := r.bottomRight.x; x >= maxInt(end, left); x-- {
+			if !nonValue(b.get(x, y)) {
+				return x + 1
+			}
+		}
+		return end
+	}
+
+	var text bytes.Buffer
+	for y := top; y <= bottom; y++ {
+		end := lineEnd(y)
+		for x := left; x < end; x++ {
+			r := b.get(x, y)
+			if r == 0 {
+				r = ' '
+			}
+			text.WriteRune(r)
+		}
+		if y < bottom || !l.unterminated {
+			text.WriteRune('\n')
+		}
+	}
+	return text.Bytes()
+}
+
+// saveFile() - write the board shaped like l to filename, and its settings to the sidecar
+// when there are any
+func (b board) saveFile(filename string, l layout) error {
+	text := b.text(l)
+	if err := backupFile(filename); err != nil {
+		return err
+	}
+	if err := replaceFile(filename, text); err != nil {
+		return err
+	}
+	if !l.settings.empty() {
+		if err := backupFile(sidecarName(filename)); err != nil {
+			return err
+		}
+		if err := replaceFile(sidecarName(filename), l.settings.text()); err != nil {
+			return err
+		}
+	}
+	setMiddleMsg(fmt.Sprintf("Saved %s, %d bytes", filename, len(text)))
+	return nil
+}
+
+// backupFile() - copy filename to filename.bak, if there is a filename
+func backupFile(filename string) error {
+	info, err := os.Stat(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	previous, err := os.ReadFile(filename)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return loadFile(filename, macroWidth, macroHeight)
+	return os.WriteFile(filename+".bak", previous, info.Mode().Perm())
 }
-func loadFile(filename string, width int, height int) (board, error) {
-	fd, err := os.Open(filename)
-	defer func(fd *os.File) { _ = fd.Close() }(fd)
+
+// replaceFile() - write data to a temporary file next to filename and rename it over filename,
+// so a failed write never leaves half a file
+func replaceFile(filename string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(filename); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
 	if err != nil {
-		return nil, err
+		return err
 	}
-	rdr := bufio.NewReader(fd)
-	y := 0
-	x := 0
-	var b board = make([][]rune, width)
-	for x := range b {
-		b[x] = make([]rune, height)
+	defer func() { _ = os.Remove(tmp.Name()) }() // fails harmlessly once renamed
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
 	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
 
-	for {
-		r, _, err := rdr.ReadRune()
-		if err == io.EOF {
-			setMiddleMsg(fmt.Sprintf("Loaded %s, into width %d, height %d", filename, width, height))
-			return b, nil
-		}
-		if err != nil {
-			return nil, err
-		}
-		if r == '\n' {
-			x = 0
-			y += 1
-			continue
+func makeBoard() board {
+	return make(board)
+}
+
+// gridOf() - a copy of the cells of b inside rectangle r
+func (b board) gridOf(r rectangle) grid {
+	g := makeGrid(r.bottomRight.x-r.topLeft.x+1, r.bottomRight.y-r.topLeft.y+1)
+	for x := r.topLeft.x; x <= r.bottomRight.x; x++ {
+		for y := r.topLeft.y; y <= r.bottomRight.y; y++ {
+			g.set(x-r.topLeft.x, y-r.topLeft.y, b.get(x, y))
 		}
-		b.set(x, y, r)
-		x += 1
 	}
+	return g
 }
 
-func (b board) saveFile(filename string) {
+// grid - a fixed size rectangle of cells, used for the cut/paste buffer
+type grid [][]rune
 
-	fd, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
-	if err != nil {
-		setMiddleMsg(err.Error())
-		return
+func makeGrid(width int, height int) grid {
+	g := make([][]rune, width)
+	for x := range g {
+		g[x] = make([]rune, height)
+		for y := range g[x] {
+			g[x][y] = ' '
+		}
 	}
-	defer func(fd *os.File) { _ = fd.Close() }(fd)
+	return g
+}
+
+// get() - the cell at x, y or ' ' if outside the grid
+func (g grid) get(x, y int) rune {
+	if x < 0 || y < 0 || x >= len(g) || y >= len(g[x]) {
+		return ' '
+	}
+	return g[x][y]
+}
 
-	var actualWidth = 0
-	var actualHeight = 0
-	for y := 0; y < len(b[0])-1; y++ {
-		var maxX = 0
-		for x := 0; x < len(b); x++ {
-			if !isZero(b[x][y]) {
-				maxX = x
+// blendInto() - copy the cells of g with values into b with the top left corner at p
+func (g grid) blendInto(b board, p coord) {
+	for x := 0; x < len(g); x++ {
+		for y := 0; y < len(g[x]); y++ {
+			if nonValue(g[x][y]) {
+				continue
 			}
+			b.set(p.x+x, p.y+y, g[x][y])
 		}
-		if maxX != 0 {
-			actualHeight = y
+	}
+}
+
+// pasteInto() - copy every cell of g into b with the top left corner at p
+func (g grid) pasteInto(b board, p coord) {
+	for x := 0; x < len(g); x++ {
+		for y := 0; y < len(g[x]); y++ {
+			b.set(p.x+x, p.y+y, g[x][y])
 		}
-		actualWidth = maxInt(actualWidth, maxX)
 	}
+}
 
-	for y := 0; y <= actualHeight; y++ {
-		for x := 0; x <= actualWidth; x++ {
-			r := b[x][y]
-			if r == 0 {
-				r = ' '
-			}
-			_, err := fmt.Fprintf(fd, "%c", r)
-			if err != nil {
-				setMiddleMsg(err.Error())
-				return
-			}
+// set() - Set a value but don't throw an error if outside the grid
+func (g grid) set(x, y int, r rune) {
+	if x < 0 || y < 0 || x >= len(g) || y >= len(g[x]) {
+		return
+	}
+	g[x][y] = r
+}
+
+// chunkOf() - the chunk holding p and the position of p within it
+func chunkOf(p coord) (coord, coord) {
+	floorDiv := func(a int) int {
+		if a < 0 {
+			return (a+1)/chunkSize - 1
 		}
-		_, err = fmt.Fprintf(fd, "\n")
-		if err != nil {
-			setMiddleMsg(err.Error())
-			return
+		return a / chunkSize
+	}
+	c := coord{floorDiv(p.x), floorDiv(p.y)}
+	return c, coord{p.x - c.x*chunkSize, p.y - c.y*chunkSize}
+}
+
+// bounds() - a rectangle enclosing every allocated chunk, cells outside it are all ' '
+func (b board) bounds() rectangle {
+	if len(b) == 0 {
+		return rectangle{coord{0, 0}, coord{-1, -1}}
+	}
+	first := true
+	var r rectangle
+	for c := range b {
+		if first {
+			r = rectangle{c, c}
+			first = false
 		}
+		r.topLeft = coord{minInt(r.topLeft.x, c.x), minInt(r.topLeft.y, c.y)}
+		r.bottomRight = coord{maxInt(r.bottomRight.x, c.x), maxInt(r.bottomRight.y, c.y)}
 	}
-	setMiddleMsg(fmt.Sprintf("Saved %s, width %d, height %d", filename, actualWidth, actualHeight))
+	return rectangle{
+		coord{r.topLeft.x * chunkSize, r.topLeft.y * chunkSize},
+		coord{(r.bottomRight.x+1)*chunkSize - 1, (r.bottomRight.y+1)*chunkSize - 1},
+	}
+}
 
+// extent() - the smallest rectangle enclosing every cell with a value,
+// bottomRight is above and left of topLeft if there are none
+func (b board) extent() rectangle {
+	return b.extentOf(func(p coord, v rune) bool {
+		return !nonValue(v)
+	})
 }
 
-func makeBoard(width int, height int) board {
+// drawnExtent() - extent() leaving out the values ticks write beside components, which may
+// be outside everything drawn, like the value of a lamp on the first line
+func (b board) drawnExtent() rectangle {
+	return b.extentOf(func(p coord, v rune) bool {
+		return !nonValue(v) && !(isDigit(v) && tickValue(b, p))
+	})
+}
 
-	b := make([][]rune, width)
-	for x := range b {
-		b[x] = make([]rune, height)
+// tickValue() - is p a cell a tick writes the value of a component into
+func tickValue(b board, p coord) bool {
+	// is() - the component dx, dy from p is one of runes
+	is := func(dx, dy int, runes string) bool {
+		return strings.ContainsRune(runes, b.get(p.x+dx, p.y+dy))
 	}
+	gates := ".+#^="
+	return is(0, 1, "L") || is(0, -1, "J") || // lamps
+		is(1, 1, gates) || is(1, -1, gates) || is(1, 0, gates) || // gates
+		is(-1, 1, "D") || // the old value of delays
+		is(-1, -1, "SZ") || is(-1, 1, "SZ") || is(0, -1, "S") || is(1, -1, "S") || is(0, 1, "Z") || is(1, 1, "Z") // relays
+}
 
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			b[x][y] = ' '
+// extentOf() - the smallest rectangle enclosing every cell keep is true for
+func (b board) extentOf(keep func(p coord, v rune) bool) rectangle {
+	r := rectangle{coord{math.MaxInt32, math.MaxInt32}, coord{math.MinInt32, math.MinInt32}}
+	b.each(func(p coord, v rune) {
+		if !keep(p, v) {
+			return
 		}
+		r.topLeft = coord{minInt(r.topLeft.x, p.x), minInt(r.topLeft.y, p.y)}
+		r.bottomRight = coord{maxInt(r.bottomRight.x, p.x), maxInt(r.bottomRight.y, p.y)}
+	})
+	if r.topLeft.x > r.bottomRight.x {
+		return rectangle{coord{0, 0}, coord{-1, -1}}
 	}
-	return b
+	return r
 }
 
-func (b board) setIfEmpty(x int, y int, r rune) {
-	if nonValue(b[x][y]) {
-		b[x][y] = r
+// each() - call fn for every cell in the allocated chunks, in no particular order
+func (b board) each(fn func(p coord, r rune)) {
+	for c, ch := range b {
+		for x := 0; x < chunkSize; x++ {
+			for y := 0; y < chunkSize; y++ {
+				fn(coord{c.x*chunkSize + x, c.y*chunkSize + y}, ch[x][y])
+			}
+		}
 	}
 }
 
-// off() - Are we off the board?
-func (b board) off(x int, y int) bool {
-	if x < 0 || y < 0 || x >= len(b) {
-		return true
+// copyInto() - make to a copy of b, reusing its chunks
+func (b board) copyInto(to board) {
+	for c, ch := range b {
+		if t, ok := to[c]; ok {
+			*t = *ch
+		} else {
+			copied := *ch
+			to[c] = &copied
+		}
 	}
-	if y >= len(b[x]) {
-		return true
+	for c := range to {
+		if _, ok := b[c]; !ok {
+			delete(to, c)
+		}
 	}
-	return false
 }
 
-// set() - Set a value but don't throw an error if outside the board
+func (b board) setIfEmpty(x int, y int, r rune) {
+	if nonValue(b.get(x, y)) {
+		b.set(x, y, r)
+	}
+}
+
+// set() - Set a value, growing the board if needed
 func (b board) set(x int, y int, r rune) {
 	b.setC(coord{x, y}, r)
 }
 
-// setC() - Set a value but don't throw an error if outside the board
+// setC() - Set a value, growing the board if needed
 func (b board) setC(p coord, r rune) {
-	if b.off(p.x, p.y) {
-		return
+	c, in := chunkOf(p)
+	ch, ok := b[c]
+	if !ok {
+		if nonValue(r) {
+			return // already blank
+		}
+		ch = &chunk{}
+		b[c] = ch
 	}
-	b[p.x][p.y] = r
+	ch[in.x][in.y] = r
 }
 
 func (v visitors) gt(p coord, max int) bool {
@@ -975,10 +1396,12 @@ func (v visitors) done(p coord) {
 
 
 func (b board) getC(p coord) rune {
-	if b.off(p.x, p.y) {
+	c, in := chunkOf(p)
+	ch, ok := b[c]
+	if !ok {
 		return ' '
 	}
-	return b[p.x][p.y]
+	return ch[in.x][in.y]
 }
 
 func (b board) get(x, y int) rune {
@@ -986,7 +1409,12 @@ func (b board) get(x, y int) rune {
 }
 
 func (b board) findCommentEnd(x int, y int) int {
-	for ; x < len(b); x++ {
+	return b.commentEnd(x, y, b.bounds().bottomRight.x)
+}
+
+// commentEnd() - the '_' closing the comment from x on row y, looking no further right than right
+func (b board) commentEnd(x int, y int, right int) int {
+	for ; x <= right; x++ {
 		if b.get(x, y) == '_' {
 			break
 		}
@@ -999,17 +1427,18 @@ func (b board) findCommentEnd(x int, y int) int {
 // if no comment found return empty string
 func (b board) getComment(p coord) interface{} {
 	msg := make([]rune, 0)
+	right := b.bounds().bottomRight.x
 	x := p.x
-	for ; x < len(b); x++ {
+	for ; x <= right; x++ {
 		if b.get(x, p.y) == '_' {
 			break
 		}
 	}
-	if x == len(b) {
+	if x > right {
 		return "" // did not find a comment
 	}
 	x += 1
-	for ; x < len(b); x++ {
+	for ; x <= right; x++ {
 		if b.get(x, p.y) == '_' {
 			break
 		}
@@ -1017,6 +1446,30 @@ func (b board) getComment(p coord) interface{} {
 	}
 	return string(msg)
 }
+// comments() - every complete comment on the board, row by row
+func (b board) comments() []label {
+	found := make([]label, 0)
+	r := b.extent()
+	for y := r.topLeft.y; y <= r.bottomRight.y; y++ {
+		for x := r.topLeft.x; x <= r.bottomRight.x; x++ {
+			if b.get(x, y) != '_' {
+				continue
+			}
+			end := b.findCommentEnd(x+1, y)
+			if b.get(end, y) != '_' {
+				break // not closed
+			}
+			text := make([]rune, 0)
+			for i := x + 1; i < end; i++ {
+				text = append(text, b.get(i, y))
+			}
+			found = append(found, label{string(text), coord{x, y}})
+			x = end
+		}
+	}
+	return found
+}
+
 func setMiddleMsgRaw(s tcell.Screen, msg string) {
 	w, _ := s.Size()
 	runes := []rune(msg)
@@ -1044,13 +1497,24 @@ const (
 	// KeysNormal State of the user interface handling of keys
 	KeysNormal = iota
 	KeysSelecting
+	KeysMoving
 )
 
 type editor struct {
 	ks                 int
 	pivot              coord
 	selectionRectangle rectangle
-	cutPasteBuffer     board
+	cutPasteBuffer     grid
+	moving             *mover
+}
+
+// mover - the selection lifted off the board while the arrow keys move it.
+// Only edits touch its cells, so they always match the working board.
+type mover struct {
+	block grid  // the lifted cells
+	under grid  // the cells the block is covering
+	at    coord // top left corner of the block
+	from  coord // where the block was lifted from
 }
 
 var theEditor = newEditor()
@@ -1062,7 +1526,7 @@ func newEditor() (e *editor) {
 			coord{0, 0},
 			coord{0, 0},
 		},
-		cutPasteBuffer: makeBoard(0, 0),
+		cutPasteBuffer: makeGrid(0, 0),
 	}
 }
 
@@ -1107,64 +1571,232 @@ func (e *editor) copy(b board) {
 		return
 	} else {
 		// in selection mode
-		e.cutPasteBuffer = makeBoard(e.selectionRectangle.bottomRight.x-e.selectionRectangle.topLeft.x+1, e.selectionRectangle.bottomRight.y-e.selectionRectangle.topLeft.y+1)
-		for x := e.selectionRectangle.topLeft.x; x <= e.selectionRectangle.bottomRight.x; x++ {
-			for y := e.selectionRectangle.topLeft.y; y <= e.selectionRectangle.bottomRight.y; y++ {
-				e.cutPasteBuffer.set(x-e.selectionRectangle.topLeft.x, y-e.selectionRectangle.topLeft.y, b.get(x, y))
-			}
-		}
+		e.cutPasteBuffer = b.gridOf(e.selectionRectangle)
 		e.ks = KeysNormal
 		cursorX = e.selectionRectangle.topLeft.x
 		cursorY = e.selectionRectangle.topLeft.y
 	}
 }
 
-func (e *editor) paste(b board, cursor coord) {
-	for x := 0; x < len(e.cutPasteBuffer); x++ {
-		for y := 0; y < len(e.cutPasteBuffer[x]); y++ {
-			b.set(cursor.x+x, cursor.y+y, e.cutPasteBuffer.get(x, y))
-		}
+// paste() - the edit which pastes the cut/paste buffer at the cursor
+func (e *editor) paste(cursor coord) edit {
+	buffer := e.cutPasteBuffer
+	return func(b board) {
+		buffer.pasteInto(b, cursor)
 	}
 }
 
-func (e *editor) cut(b board, cursor coord) {
+// cut() - copy the selection from the snapshot b and return the edit which clears it
+func (e *editor) cut(b board, cursor coord) edit {
 	if e.ks == KeysNormal {
-		return
+		return nil
 	} else {
 		e.copy(b)
 		e.ks = KeysSelecting // TODO
-		e.delete(b, cursor)
-		e.ks = KeysNormal
+		return e.delete(cursor)
+	}
+}
+
+// pasteTransparent() - the edit which pastes the cut/paste buffer at the cursor
+// without overwriting the board with its blank cells
+func (e *editor) pasteTransparent(cursor coord) edit {
+	buffer := e.cutPasteBuffer
+	return func(b board) {
+		buffer.blendInto(b, cursor)
 	}
 }
 
-func (e *editor) delete(b board, cursor coord) {
+// lift() - start moving the selection, returns the edit which lifts it off the board
+func (e *editor) lift() edit {
+	if e.ks != KeysSelecting {
+		return nil
+	}
+	r := e.selectionRectangle
+	m := &mover{at: r.topLeft, from: r.topLeft}
+	e.moving = m
+	e.ks = KeysMoving
+	return func(b board) {
+		m.block = b.gridOf(r)
+		for x := r.topLeft.x; x <= r.bottomRight.x; x++ {
+			for y := r.topLeft.y; y <= r.bottomRight.y; y++ {
+				b.set(x, y, ' ')
+			}
+		}
+		m.under = b.gridOf(r)
+		m.block.pasteInto(b, m.at)
+	}
+}
+
+// shift() - the edit which moves the lifted block by dx, dy putting back what was under it
+func (e *editor) shift(dx, dy int) edit {
+	m := e.moving
+	r := &e.selectionRectangle
+	r.topLeft = coord{r.topLeft.x + dx, r.topLeft.y + dy}
+	r.bottomRight = coord{r.bottomRight.x + dx, r.bottomRight.y + dy}
+	cursorX += dx
+	cursorY += dy
+	return func(b board) {
+		m.under.pasteInto(b, m.at)
+		m.at = coord{m.at.x + dx, m.at.y + dy}
+		m.under = b.gridOf(rectangle{m.at, coord{m.at.x + m.block.width() - 1, m.at.y + m.block.height() - 1}})
+		m.block.pasteInto(b, m.at)
+	}
+}
+
+// handleMoving() - keys while moving the selection
+func (e *editor) handleMoving(ev *tcell.EventKey) edit {
+	switch ev.Key() {
+	case tcell.KeyUp:
+		return e.shift(0, -1)
+	case tcell.KeyDown:
+		return e.shift(0, 1)
+	case tcell.KeyLeft:
+		return e.shift(-1, 0)
+	case tcell.KeyRight:
+		return e.shift(1, 0)
+	case tcell.KeyEnter, tcell.KeyF9:
+		// drop it here
+		e.moving = nil
+		e.ks = KeysSelecting
+	case tcell.KeyEscape:
+		// put it back where it came from
+		r := e.selectionRectangle
+		back := e.shift(e.moving.from.x-r.topLeft.x, e.moving.from.y-r.topLeft.y)
+		e.moving = nil
+		e.ks = KeysSelecting
+		return back
+	default:
+	}
+	return nil
+}
+
+// delete() - the edit which clears the cursor cell or the selection
+func (e *editor) delete(cursor coord) edit {
 	if e.ks == KeysNormal {
-		b.set(cursor.x, cursor.y, ' ')
+		return func(b board) {
+			b.set(cursor.x, cursor.y, ' ')
+		}
 	} else {
 		// in selection mode
-		for x := e.selectionRectangle.topLeft.x; x <= e.selectionRectangle.bottomRight.x; x++ {
-			for y := e.selectionRectangle.topLeft.y; y <= e.selectionRectangle.bottomRight.y; y++ {
-				b.set(x, y, ' ')
+		r := e.selectionRectangle
+		e.ks = KeysNormal
+		cursorX = r.topLeft.x
+		cursorY = r.topLeft.y
+		return func(b board) {
+			for x := r.topLeft.x; x <= r.bottomRight.x; x++ {
+				for y := r.topLeft.y; y <= r.bottomRight.y; y++ {
+					b.set(x, y, ' ')
+				}
 			}
 		}
-		e.ks = KeysNormal
-		cursorX = e.selectionRectangle.topLeft.x
-		cursorY = e.selectionRectangle.topLeft.y
 	}
 }
 
-func (e *editor) style(p coord, cellStyle tcell.Style) tcell.Style {
-	if e.ks == KeysSelecting && e.selectionRectangle.inside(p) {
-		return cellStyle.Background(tcell.ColorLightSlateGray)
+// mark() - highlight the part of the selection inside the rectangle on the screen
+func (e *editor) mark(m marks, screen rectangle) {
+	if e.ks != KeysSelecting && e.ks != KeysMoving {
+		return
+	}
+	r := e.selectionRectangle
+	for y := maxInt(r.topLeft.y, screen.topLeft.y); y <= minInt(r.bottomRight.y, screen.bottomRight.y); y++ {
+		for x := maxInt(r.topLeft.x, screen.topLeft.x); x <= minInt(r.bottomRight.x, screen.bottomRight.x); x++ {
+			m[coord{x, y}] = tcell.ColorLightSlateGray
+		}
+	}
+}
+
+// viewport - the part of the board shown on the screen, the last screen row is the status line
+type viewport struct {
+	origin coord
+	width  int
+	height int
+}
+
+var theViewport viewport
+
+// setSize() - size the viewport for a screen of width x height
+func (v *viewport) setSize(screenWidth, screenHeight int) {
+	v.width = screenWidth
+	v.height = maxInt(screenHeight-1, 1)
+}
+
+// follow() - scroll just enough to keep the cursor in view
+func (v *viewport) follow(cursor coord) {
+	if cursor.x < v.origin.x {
+		v.origin.x = cursor.x
+	}
+	if cursor.x >= v.origin.x+v.width {
+		v.origin.x = cursor.x - v.width + 1
+	}
+	if cursor.y < v.origin.y {
+		v.origin.y = cursor.y
 	}
-	return cellStyle
+	if cursor.y >= v.origin.y+v.height {
+		v.origin.y = cursor.y - v.height + 1
+	}
+}
+
+// center() - scroll so that p is in the middle of the screen
+func (v *viewport) center(p coord) {
+	v.origin = coord{p.x - v.width/2, p.y - v.height/2}
+}
+
+// page() - scroll by whole screens and move the cursor with the view
+func (v *viewport) page(dx, dy int) {
+	v.origin.x += dx * v.width
+	v.origin.y += dy * v.height
+	cursorX += dx * v.width
+	cursorY += dy * v.height
+}
+
+// toBoard() - the board location shown at screen location x, y
+func (v *viewport) toBoard(x, y int) coord {
+	return coord{x + v.origin.x, y + v.origin.y}
 }
 
 var renderTime = flag.Duration("renderTime", 100 * time.Millisecond, "How frequently to refresh the screen.")
 var clockSpeed = flag.Duration("clockSpeed", 50 * time.Millisecond, "How frequently to run the interpreter.")
+var autosave = flag.Duration("autosave", 0, "How often to save changed boards, 0 for never.")
 var renderStyle = flag.String("renderStyle", "unicode", "Render style [plain, unicode], default unicode.")
 
+// drawStyle - the render style of the board being drawn, from its settings
+var drawStyle = "unicode"
+
+// keepsSelection - keys which act on the selection so don't end it
+var keepsSelection = map[tcell.Key]bool{
+	tcell.KeyDelete: true,
+	tcell.KeyCtrlC:  true,
+	tcell.KeyCtrlX:  true,
+	tcell.KeyCtrlF:  true,
+	tcell.KeyCtrlR:  true,
+	tcell.KeyF6:     true,
+	tcell.KeyF7:     true,
+	tcell.KeyF8:     true,
+	tcell.KeyF9:     true,
+	tcell.KeyF10:    true,
+	tcell.KeyF11:    true,
+	tcell.KeyCtrlP:  true, // for :export
+}
+
+// toggle() - the edit which flips a cell between '0' and '1'
+func toggle(x, y int) edit {
+	return func(b board) {
+		r := b.get(x, y)
+		if nonValue(r) || r == '0' {
+			b.set(x, y, '1')
+		} else {
+			b.set(x, y, '0')
+		}
+	}
+}
+
+// setCell() - the edit which sets one cell
+func setCell(x, y int, r rune) edit {
+	return func(b board) {
+		b.set(x, y, r)
+	}
+}
+
 // var prof interface{ Stop() } // Keep this line
 
 func main() {
@@ -1172,6 +1804,38 @@ func main() {
 	//prof = profile.Start(profile.CPUProfile, profile.ProfilePath(".")) 	// Keep this line
 
 	flag.Parse()
+	switch flag.Arg(0) {
+	case "export":
+		if err := exportMain(flag.Args()[1:]); err != nil {
+			log.Fatalf("ERROR: %s\n", err)
+		}
+		return
+	case "record":
+		if err := recordMain(flag.Args()[1:]); err != nil {
+			log.Fatalf("ERROR: %s\n", err)
+		}
+		return
+	case "verilog":
+		if err := verilogMain(flag.Args()[1:]); err != nil {
+			log.Fatalf("ERROR: %s\n", err)
+		}
+		return
+	case "import":
+		if err := importMain(flag.Args()[1:]); err != nil {
+			log.Fatalf("ERROR: %s\n", err)
+		}
+		return
+	case "truth":
+		if err := truthMain(flag.Args()[1:]); err != nil {
+			log.Fatalf("ERROR: %s\n", err)
+		}
+		return
+	case "lint":
+		if err := lintMain(flag.Args()[1:]); err != nil {
+			log.Fatalf("ERROR: %s\n", err)
+		}
+		return
+	}
 
 	logfd, err := os.OpenFile("log.txt", os.O_RDWR|os.O_CREATE, 0644)
 	if err != nil {
@@ -1179,16 +1843,6 @@ func main() {
 	}
 	defer func(fd *os.File) { _ = fd.Close() }(logfd)
 
-	var theBoard board
-
-	setLeftMsg = func(msg string) {
-		runes := []rune(msg)
-		for i, r := range runes {
-			theBoard.set(i, height-1, r)
-		}
-	}
-
-	filename := "untitled.betula"
 
 	s, err := tcell.NewScreen()
 	if err != nil {
@@ -1200,6 +1854,12 @@ func main() {
 	setMiddleMsg = func(msg string) {
 		setMiddleMsgRaw(s, msg)
 	}
+	setLeftMsg = func(msg string) {
+		runes := []rune(msg)
+		for i, r := range runes {
+			s.SetContent(i, theViewport.height, r, nil, tcell.StyleDefault)
+		}
+	}
 
 	beep = func() {
 		_ = s.Beep()
@@ -1217,139 +1877,222 @@ func main() {
 	cursorX = screenWidth / 2
 	cursorY = screenHeight / 2
 
-	if flag.Arg(0) != "" {
-		filename = flag.Arg(0)
-		var err error
-		fileWidth, fileHeight, err := sizeOfFile(filename)
-		if err != nil {
-			log.Fatalf("ERROR: file %s - %s\n", os.Args[1], err)
-		}
-		_, _ = fmt.Fprintf(logfd, "fileWidth %d, fileHeight %d\n", fileWidth, fileHeight)
-		width = maxInt(fileWidth, screenWidth)
-		height = maxInt(fileHeight, screenHeight)
-		theBoard, err = loadFile(filename, width, height)
+	theViewport.setSize(screenWidth, screenHeight)
+	for _, filename := range flag.Args() {
+		b, l, err := loadFile(filename)
 		if err != nil {
-			log.Fatalf("ERROR: file %s - %s\n", os.Args[1], err)
+			log.Fatalf("ERROR: file %s - %s\n", filename, err)
 		}
-	} else {
-		width = screenWidth
-		height = screenHeight
-		theBoard = makeBoard(width, height)
+		addBuffer(filename, b, l)
+	}
+	if len(buffers) == 0 {
+		addBuffer("untitled.betula", makeBoard(), layout{})
 	}
+	switchBuffer(0)
+	s.EnableMouse()
+	if *autosave > 0 {
+		go func() {
+			for range time.Tick(*autosave) {
+				_ = s.PostEvent(tcell.NewEventInterrupt(nil))
+			}
+		}()
+	}
+	quitArmed := false // Ctrl-Q was pressed with unsaved changes
 	quit := func() {
+		s.DisableMouse()
 		s.Fini()
-		s.EnableMouse()
 		// prof.Stop() // Keep this line
 		os.Exit(0)
 	}
-	go interpreter(theBoard)
-	go render(s, theBoard)
+	go render(s)
+
+	// peek() - read a cell from the latest published board
+	peek := func(x, y int) rune {
+		var r rune
+		snapshot(func(b board, _ int) {
+			r = b.get(x, y)
+		})
+		return r
+	}
+
+	// transform() - rotate or mirror the selection or the paste buffer
+	transform := func(fn func(g grid) grid) {
+		var t edit
+		snapshot(func(b board, _ int) {
+			t = theEditor.transform(b, fn)
+		})
+		submit(t)
+	}
 
 	for {
 		// Poll event
 		ev := s.PollEvent()
 
 		// Process event
+		uiMutex.Lock()
 		switch ev := ev.(type) {
+		case *tcell.EventInterrupt:
+			autosaveAll()
 		case *tcell.EventResize:
+			theViewport.setSize(ev.Size())
+			theViewport.follow(coord{cursorX, cursorY})
 			s.Sync()
 		case *tcell.EventKey:
-			if ev.Modifiers()&tcell.ModShift == 0 && ev.Key() != tcell.KeyDelete && ev.Key() != tcell.KeyCtrlC && ev.Key() != tcell.KeyCtrlX { // TODO
+			theCommandLine.help = nil
+			theInspector.hovering = false // back to the cursor
+			confirmQuit := quitArmed
+			quitArmed = false
+			if theCommandLine.on {
+				theCommandLine.handle(ev)
+				break
+			}
+			if theOverview.on {
+				theOverview.handle(ev)
+				break
+			}
+			if theFinder.on {
+				theFinder.handle(ev)
+				break
+			}
+			if theSearch.on {
+				theSearch.handle(ev)
+				break
+			}
+			if theEditor.ks == KeysMoving {
+				submit(theEditor.handleMoving(ev))
+				break
+			}
+			if ev.Modifiers()&tcell.ModShift == 0 && !keepsSelection[ev.Key()] { // TODO
 				theEditor.noShift()
 			}
 			switch ev.Key() {
 			case tcell.KeyCtrlQ:
+				if names := unsaved(); len(names) > 0 && !confirmQuit {
+					quitArmed = true
+					setMiddleMsg(fmt.Sprintf("Unsaved changes in %s - Ctrl-Q again to quit", strings.Join(names, " ")))
+					break
+				}
 				quit()
+			case tcell.KeyF2:
+				snapshot(func(b board, _ int) {
+					theOverview.toggle(b)
+				})
+			case tcell.KeyF10:
+				theInspector.toggle()
+			case tcell.KeyF11:
+				theNetHighlight.on = !theNetHighlight.on
+			case tcell.KeyF6:
+				transform(grid.rotateWarning)
+			case tcell.KeyF7:
+				transform(grid.mirrorLeftRightWarning)
+			case tcell.KeyF8:
+				transform(grid.mirrorTopBottomWarning)
+			case tcell.KeyCtrlP:
+				theCommandLine.open()
+			case tcell.KeyCtrlO:
+				theCommandLine.open()
+				theCommandLine.input.text = []rune("e ")
+			case tcell.KeyCtrlN:
+				switchBuffer(currentBuffer + 1)
+			case tcell.KeyCtrlF:
+				theSearch.find()
+			case tcell.KeyCtrlR:
+				theSearch.replace()
+			case tcell.KeyF3:
+				snapshot(func(b board, _ int) {
+					theSearch.next(b)
+				})
+			case tcell.KeyEscape:
+				theSearch.clear()
+				theRouter.cancel()
+				cancelJob()
+			case tcell.KeyCtrlW:
+				var wire edit
+				snapshot(func(b board, _ int) {
+					wire = theRouter.mark(b, coord{cursorX, cursorY})
+				})
+				submit(wire)
+			case tcell.KeyCtrlG:
+				snapshot(func(b board, _ int) {
+					theFinder.open(b)
+				})
 			case tcell.KeyF5:
 				// Toggle the value under the cursor
-				boardMutex.Lock()
-				r := theBoard[cursorX][cursorY]
-				if nonValue(r) || r == '0' {
-					theBoard.set(cursorX, cursorY, '1')
-				} else {
-					theBoard.set(cursorX, cursorY, '0')
-				}
-				boardMutex.Unlock()
+				submit(toggle(cursorX, cursorY))
 			case tcell.KeyDelete:
-				boardMutex.Lock()
-				theEditor.delete(theBoard, coord{cursorX, cursorY})
-				boardMutex.Unlock()
+				submit(theEditor.delete(coord{cursorX, cursorY}))
 				// follow wires
-				if !nonValue(theBoard.get(cursorX+1, cursorY)) {
+				if !nonValue(peek(cursorX+1, cursorY)) {
 					cursorX += 1
-				} else if !nonValue(theBoard.get(cursorX-1, cursorY)) {
+				} else if !nonValue(peek(cursorX-1, cursorY)) {
 					cursorX -= 1
-				} else if !nonValue(theBoard.get(cursorX, cursorY+1)) {
+				} else if !nonValue(peek(cursorX, cursorY+1)) {
 					cursorY += 1
-				} else if !nonValue(theBoard.get(cursorX, cursorY-1)) {
+				} else if !nonValue(peek(cursorX, cursorY-1)) {
 					cursorY -= 1
 				}
 			case tcell.KeyCtrlC:
-				boardMutex.Lock()
-				theEditor.copy(theBoard)
-				boardMutex.Unlock()
+				snapshot(func(b board, _ int) {
+					theEditor.copy(b)
+				})
 			case tcell.KeyCtrlV:
-				boardMutex.Lock()
-				theEditor.paste(theBoard, coord{cursorX, cursorY})
-				boardMutex.Unlock()
+				submit(theEditor.paste(coord{cursorX, cursorY}))
+			case tcell.KeyCtrlB:
+				submit(theEditor.pasteTransparent(coord{cursorX, cursorY}))
+			case tcell.KeyF9:
+				submit(theEditor.lift())
 			case tcell.KeyCtrlX:
-				boardMutex.Lock()
-				theEditor.cut(theBoard, coord{cursorX, cursorY})
-				boardMutex.Unlock()
+				var cut edit
+				snapshot(func(b board, _ int) {
+					cut = theEditor.cut(b, coord{cursorX, cursorY})
+				})
+				submit(cut)
 			case tcell.KeyBackspace2:
 				if cursorX > 0 {
 					cursorX -= 1
 				}
-				boardMutex.Lock()
-				theBoard.set(cursorX, cursorY, ' ')
-				boardMutex.Unlock()
+				submit(setCell(cursorX, cursorY, ' '))
 			case tcell.KeyUp:
-				if cursorY != 0 {
-					theEditor.move(coord{cursorX, cursorY}, coord{cursorX, cursorY - 1}, ev.Modifiers())
-					cursorY -= 1
-				}
+				theEditor.move(coord{cursorX, cursorY}, coord{cursorX, cursorY - 1}, ev.Modifiers())
+				cursorY -= 1
 			case tcell.KeyDown:
-				if cursorY < height-2 {
-					theEditor.move(coord{cursorX, cursorY}, coord{cursorX, cursorY + 1}, ev.Modifiers())
-					cursorY += 1
-				}
+				theEditor.move(coord{cursorX, cursorY}, coord{cursorX, cursorY + 1}, ev.Modifiers())
+				cursorY += 1
 			case tcell.KeyLeft:
-				if cursorX != 0 {
-					theEditor.move(coord{cursorX, cursorY}, coord{cursorX - 1, cursorY}, ev.Modifiers())
-					cursorX -= 1
-				}
+				theEditor.move(coord{cursorX, cursorY}, coord{cursorX - 1, cursorY}, ev.Modifiers())
+				cursorX -= 1
 			case tcell.KeyRight:
-				if cursorX < width-1 {
-					theEditor.move(coord{cursorX, cursorY}, coord{cursorX + 1, cursorY}, ev.Modifiers())
-					cursorX += 1
-				}
+				theEditor.move(coord{cursorX, cursorY}, coord{cursorX + 1, cursorY}, ev.Modifiers())
+				cursorX += 1
+			case tcell.KeyPgUp:
+				theViewport.page(0, -1)
+			case tcell.KeyPgDn:
+				theViewport.page(0, 1)
+			case tcell.KeyHome:
+				theViewport.page(-1, 0)
+			case tcell.KeyEnd:
+				theViewport.page(1, 0)
 			case tcell.KeyF4: // for inside the debugger
-				boardMutex.Lock()
-				theBoard.saveFile(filename)
-				boardMutex.Unlock()
+				current().write()
 			case tcell.KeyCtrlS:
-				boardMutex.Lock()
-				theBoard.saveFile(filename)
-				boardMutex.Unlock()
+				current().write()
 			case tcell.KeyRune:
 				k := ev.Rune()
-				boardMutex.Lock()
-				theBoard.set(cursorX, cursorY, k)
-				boardMutex.Unlock()
+				submit(setCell(cursorX, cursorY, k))
 				// follow wires, user-friendly cursor positions
 				switch k {
 				case '*':
 					cursorX -= 1
 				case '|':
-					if nonValue(theBoard[cursorX][cursorY+1]) {
+					if nonValue(peek(cursorX, cursorY+1)) {
 						cursorY += 1
-					} else if nonValue(theBoard[cursorX][cursorY-1]) {
+					} else if nonValue(peek(cursorX, cursorY-1)) {
 						cursorY -= 1
 					}
 				case '-':
-					if nonValue(theBoard[cursorX+1][cursorY]) {
+					if nonValue(peek(cursorX+1, cursorY)) {
 						cursorX += 1
-					} else if nonValue(theBoard[cursorX-1][cursorY]) {
+					} else if nonValue(peek(cursorX-1, cursorY)) {
 						cursorX -= 1
 					}
 				default:
@@ -1358,13 +2101,26 @@ func main() {
 			default:
 			}
 		case *tcell.EventMouse:
-			cursorX, cursorY = ev.Position()
+			if x, y := ev.Position(); theOverview.on {
+				if ev.Buttons()&tcell.Button1 != 0 && y < theViewport.height {
+					theOverview.jump(x, y)
+				}
+			} else {
+				theMouse.handle(ev)
+			}
 		}
+		theViewport.follow(coord{cursorX, cursorY})
+		uiMutex.Unlock()
 	}
 }
 
 func fancy(r rune) rune {
-	if *renderStyle != "unicode" {
+	return fancyIn(drawStyle, r)
+}
+
+// fancyIn() - r as drawn in the render style, for drawing away from the screen
+func fancyIn(style string, r rune) rune {
+	if style != "unicode" {
 		return r
 	}
 	f, ok := boxDrawRunes[r]
@@ -1440,8 +2196,36 @@ var backgrounds = map[rune]tcell.Color{
 	'D': tcell.ColorLightGreen,
 }
 
+// styleRow() - call fn with each cell of row y from x = from up to x = to, and its style on the screen.
+// Comments run between '_' cells and may start to the left of from.
+func styleRow(b board, y int, from int, to int, fn func(x int, val rune, sty tcell.Style)) {
+	inComment := false // parsing state
+	for x := b.bounds().topLeft.x; x < from; x++ {
+		if b.get(x, y) == '_' {
+			inComment = !inComment
+		}
+	}
+	for x := from; x < to; x++ {
+		val := b.get(x, y)
+		sty := styleOf(val)
+		if val == '_' { // Scan and display the comment
+			sty = commentStyle
+			inComment = !inComment
+		}
+		if inComment {
+			sty = commentStyle
+		}
+		fn(x, val, sty)
+	}
+}
+
+var commentStyle = tcell.StyleDefault.Foreground(colors['_'])
+
 var runeStyleCache = map[rune]tcell.Style{} // for performance // TODO hide in closure
+var runeStyleMutex sync.Mutex               // styles are also needed outside the render goroutine, by exports
 func styleOf(r rune) tcell.Style {
+	runeStyleMutex.Lock()
+	defer runeStyleMutex.Unlock()
 	result, ok := runeStyleCache[r]
 	if ok {
 		return result
@@ -1474,28 +2258,65 @@ func styleOf(r rune) tcell.Style {
 
 }
 
-func view(s tcell.Screen, b board) {
-	boardMutex.Lock()
-	commentStyle := tcell.StyleDefault.Foreground(colors['_'])
-	for y := 0; y < height-1; y++ {
-		inComment := false // parsing state
-		for x := 0; x < width; x++ {
-			val := b.get(x, y)
-			sty := styleOf(val)
-			if val == '_' { // Scan and display the comment
-				sty = commentStyle
-				inComment = !inComment
-			}
-			if inComment {
-				sty = commentStyle
+// view() - paint the latest publication of the current board, without holding its lock
+func view(s tcell.Screen) {
+	v := theViewport
+	m := current().m
+	pub := m.latest()
+	b, ticks := pub.b, pub.ticks
+	if theOverview.on {
+		theOverview.view(s, b)
+		return
+	}
+	defer func() {
+		if theFinder.on {
+			theFinder.view(s)
+		}
+		if theSearch.on {
+			theSearch.view(s)
+		}
+		if theInspector.on {
+			theInspector.view(s, m, pub)
+		}
+		theCommandLine.view(s)
+	}()
+	// the backgrounds of the marked cells, each over those marked before it
+	marked := marks{}
+	pub.warnings.mark(marked)
+	theNetHighlight.cells(m, pub).mark(b, marked)
+	theSearch.mark(b, marked)
+	theLint.mark(marked)
+	theRouter.markStart(marked)
+	theEditor.mark(marked, rectangle{v.origin, coord{v.origin.x + v.width - 1, v.origin.y + v.height - 1}})
+	wires := theSignalColours.carried(pub)
+	for sy := 0; sy < v.height; sy++ {
+		y := v.origin.y + sy
+		styleRow(b, y, v.origin.x, v.origin.x+v.width, func(x int, val rune, sty tcell.Style) {
+			p := coord{x, y}
+			sty = wires.style(p, val, sty)
+			if c, ok := marked[p]; ok {
+				sty = sty.Background(c)
 			}
-			stile := theEditor.style(coord{x, y}, sty)
-			s.SetContent(x, y, fancy(b.get(x, y)), nil, stile)
+			s.SetContent(x-v.origin.x, sy, fancy(val), nil, sty)
+		})
+	}
+	theWarningReport.report(m, pub.warnings)
+	for sx := 0; sx < v.width; sx++ {
+		s.SetContent(sx, v.height, ' ', nil, tcell.StyleDefault)
+	}
+	val := b.get(cursorX, cursorY)
+	status := fmt.Sprintf("%d %3d %3d %c %2d", ticks, cursorX, cursorY, val, rune2Int(val))
+	if bu := current(); len(buffers) > 1 || bu.dirty() {
+		status += fmt.Sprintf("  %d/%d %s", currentBuffer+1, len(buffers), bu.filename)
+		if bu.dirty() {
+			status += " [+]"
 		}
 	}
-	for x := 0; x < width; x++ {
-		s.SetContent(x, height-1, fancy(b[x][height-1]), nil, tcell.StyleDefault)
-	}
-	s.SetContent(cursorX, cursorY, b.get(cursorX, cursorY), nil, tcell.StyleDefault.Reverse(true))
-	boardMutex.Unlock()
+	setLeftMsg(status)
+	s.SetContent(cursorX-v.origin.x, cursorY-v.origin.y, val, nil, cursorStyle)
 }
+
+// marks - the backgrounds which mark cells on the screen, worked out once for each paint
+type marks map[coord]tcell.Color
+
+var cursorStyle = tcell.StyleDefault.Reverse(true)
@@ -0,0 +1,613 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	setMiddleMsg = func(string) {}
+	setLeftMsg = func(string) {}
+	beep = func() {}
+}
+
+// roundTrip() - load filename and check the board saves to exactly the bytes of the file
+func roundTrip(t *testing.T, filename string) {
+	t.Helper()
+	want, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, l, err := loadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := b.text(l); string(got) != string(want) {
+		t.Errorf("%s saves as\n%q\nnot\n%q", filename, got, want)
+	}
+}
+
+func TestSamplesRoundTrip(t *testing.T) {
+	samples, err := filepath.Glob("*.betula")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) == 0 {
+		t.Fatal("no sample boards")
+	}
+	for _, filename := range samples {
+		t.Run(filename, func(t *testing.T) {
+			roundTrip(t, filename)
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		text string
+	}{
+		{"empty", ""},
+		{"no newline at the end", "0*--L\n1*--L"},
+		{"blank lines", "\n0*--L\n\n\n1*--L\n\n"},
+		{"trailing spaces", "0*--L   \n1*--L \n     \n"},
+		{"only column 0", "0\n\n_\n|\n"},
+		{"ragged", "0*\n0*----L\n\n1*-L\n"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "board.betula")
+			if err := os.WriteFile(filename, []byte(c.text), 0644); err != nil {
+				t.Fatal(err)
+			}
+			roundTrip(t, filename)
+		})
+	}
+}
+
+// TestRunningKeepsShape - running a board shows the values of its lamps and the bodies of
+// its macros, but saving it writes only what is drawn
+func TestRunningKeepsShape(t *testing.T) {
+	for _, c := range []struct {
+		text string
+		at   coord // a cell running the board fills in
+		want rune  // with this, or anything but blank when 0
+	}{
+		{"1*--L\n", coord{4, -1}, '1'},               // the lamp's value is above the first line
+		{"1*--J\n", coord{4, 1}, '1'},                // and below the last
+		{"\n    1\n1*--L\n", coord{4, 1}, '1'},       // the value is in the file already
+		{"_a_ 1*--L\n  0*--J  \n", coord{6, 2}, '0'}, // ragged
+		{"\n\n1*--L\n", coord{4, 1}, '1'},            // blank lines above
+		{"1*--Mspdt\n", coord{4, 1}, 0},              // a macro body below
+	} {
+		filename := filepath.Join(t.TempDir(), "board.betula")
+		if err := os.WriteFile(filename, []byte(c.text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		b, l, err := loadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		headlessMachine(b).run(3)
+		if got := b.getC(c.at); got != c.want && (c.want != 0 || nonValue(got)) {
+			t.Errorf("%q has %q at %v after running, not %q", c.text, got, c.at, c.want)
+		}
+		if c.want == 0 || strings.HasPrefix(c.text, "\n\n") {
+			continue // the macro body and the value on the blank line are saved as they were drawn
+		}
+		if got := string(b.text(l)); got != c.text {
+			t.Errorf("%q saves as %q after running", c.text, got)
+		}
+	}
+}
+
+func TestHeadlessKeepsRunning(t *testing.T) {
+	b := makeBoard()
+	for x, r := range "1*--E" {
+		b.set(x, 0, r)
+	}
+	b.set(0, 1, '1')
+	b.set(1, 1, '*')
+	b.set(2, 1, 'B')
+	beep = func() {
+		t.Error("a headless machine beeped")
+	}
+	defer func() {
+		beep = func() {}
+	}()
+	headlessMachine(b).run(3) // the test stops here if the 'E' exits
+}
+
+func TestTruthTable(t *testing.T) {
+	b, _, err := loadFile("xor.betula")
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := truthTable(b, b.extent(), func(int, int) bool {
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := " *35,10 *35,16 | L39,13\n 0      0      | 0\n 0      1      | 1\n 1      0      | 1\n 1      1      | 0\n"
+	if table != want {
+		t.Errorf("xor.betula has the truth table\n%s\nnot\n%s", table, want)
+	}
+	rows := 0
+	_, _ = truthTable(b, b.extent(), func(done int, _ int) bool {
+		rows = done
+		return done < 2
+	})
+	if rows != 2 {
+		t.Errorf("stopped after %d rows, not 2", rows)
+	}
+}
+
+func TestImport(t *testing.T) {
+	netlist := filepath.Join(t.TempDir(), "gates.txt")
+	if err := os.WriteFile(netlist, []byte("input a b\noutput y n\ny = XOR a b # y is first but placed last\nn = NOT a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b, problems, err := importNetlist(netlist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) > 0 {
+		t.Errorf("importing found %v", problems)
+	}
+	table, err := truthTable(b, b.extent(), func(int, int) bool {
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := " a b | n y\n 0 0 | 1 0\n 0 1 | 1 1\n 1 0 | 0 1\n 1 1 | 0 0\n"
+	if table != want {
+		t.Errorf("the imported board has the truth table\n%s\nnot\n%s", table, want)
+	}
+}
+
+func TestVerilog(t *testing.T) {
+	b, _, err := loadFile("xor.betula")
+	if err != nil {
+		t.Fatal(err)
+	}
+	makeMachine(b).expandMacros(b)
+	text, problems := verilog(b, "xor.betula")
+	if len(problems) > 0 {
+		t.Errorf("exporting found %v", problems)
+	}
+	want := `// xor.betula exported by betula. Signals are one bit, every value but 0 is 1.
+module board_xor (
+	input wire in_35_10, // '*' at 35 10, 0 on the board
+	input wire in_35_16, // '*' at 35 16, 1 on the board
+	output wire lamp_39_13 // 'L' at 39 13
+);
+	wire gate_35_13; // '#' at 35 13
+	assign gate_35_13 = in_35_10 ^ in_35_16;
+	assign lamp_39_13 = gate_35_13;
+endmodule
+`
+	if text != want {
+		t.Errorf("xor.betula exports as\n%s\nnot\n%s", text, want)
+	}
+}
+
+func TestSettingsKeepWhatTheyDontRead(t *testing.T) {
+	text := `version = 1 # the first
+seed = 42 # a comment, not part of the value
+probes = [
+  [3, 4], # seed = 7
+  [10, 2],
+]
+title = "a # in a string"
+not toml at all
+
+[extra]
+renderStyle = "plain"
+`
+	filename := filepath.Join(t.TempDir(), "board.betula")
+	if err := os.WriteFile(sidecarName(filename), []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	warned := ""
+	setMiddleMsg = func(msg string) {
+		warned = msg
+	}
+	defer func() {
+		setMiddleMsg = func(string) {}
+	}()
+	s, err := loadSettings(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(warned, "line 8") {
+		t.Errorf("warned %q, not about line 8", warned)
+	}
+	if string(s.text()) != text {
+		t.Errorf("saves as\n%s\nnot\n%s", s.text(), text)
+	}
+	if seed, ok := s.int("seed"); !ok || seed != 42 {
+		t.Errorf("seed is %d, %v", seed, ok)
+	}
+	if title, _ := s.string("title"); title != "a # in a string" {
+		t.Errorf("title is %q", title)
+	}
+	if _, ok := s.get("renderStyle"); ok {
+		t.Error("read a key of a table")
+	}
+	got := string(s.set("seed", "7").set("clockSpeed", `"20ms"`).text())
+	want := strings.Replace(text, "seed = 42 #", "seed = 7 #", 1)
+	want = strings.Replace(want, "\n\n[extra]", "\nclockSpeed = \"20ms\"\n\n[extra]", 1)
+	if got != want {
+		t.Errorf("set saves as\n%s\nnot\n%s", got, want)
+	}
+
+	// the seed read and the one set with :seed are saved again
+	m := makeMachine(makeBoard())
+	m.clockSpeed = 20 * time.Millisecond
+	s.apply(m)
+	if got := string(s.update(m).text()); got != strings.Replace(want, "seed = 7 #", "seed = 42 #", 1) {
+		t.Errorf("saves as\n%s\nafter reading", got)
+	}
+	m.reseed(7)
+	if got := string(s.update(m).text()); got != want {
+		t.Errorf("saves as\n%s\nafter seeding", got)
+	}
+	if got := string(settings{}.update(m).text()); got != "version = 1\nclockSpeed = \"20ms\"\nseed = 7\n" {
+		t.Errorf("a new sidecar is\n%s", got)
+	}
+}
+
+func TestSettingsStayWithTheirBoard(t *testing.T) {
+	seeded := func(seed string) *machine {
+		b := makeBoard()
+		for y, line := range []string{"9R-J", "   0"} {
+			for x, r := range line {
+				b.set(x, y, r)
+			}
+		}
+		m := makeMachine(b)
+		settings{[]string{"seed = " + seed}}.apply(m)
+		return m
+	}
+	numbers := func(m *machine) string {
+		m.step()
+		return string(m.b.get(3, 1))
+	}
+	m1, m2 := seeded("5"), seeded("5")
+	got, want := "", ""
+	for i := 0; i < 20; i++ {
+		got += numbers(m1)
+		numbers(seeded("6")) // another board seeded as it starts
+		want += numbers(m2)
+	}
+	if got != want {
+		t.Errorf("seeding another board changed the numbers from %s to %s", want, got)
+	}
+
+	plain := settings{[]string{`renderStyle = "plain"`}}
+	addBuffer("plain.betula", makeBoard(), layout{settings: plain})
+	addBuffer("unicode.betula", makeBoard(), layout{})
+	defer func() {
+		for len(buffers) > 1 {
+			_ = closeBuffer(true)
+		}
+		buffers[0].m.stop()
+		buffers = nil
+	}()
+	if drawStyle != "unicode" {
+		t.Errorf("drawing %s as %s", buffers[currentBuffer].filename, drawStyle)
+	}
+	switchBuffer(currentBuffer - 1)
+	if drawStyle != "plain" {
+		t.Errorf("drawing %s as %s", buffers[currentBuffer].filename, drawStyle)
+	}
+}
+
+func TestTransformWarnings(t *testing.T) {
+	warned := ""
+	setMiddleMsg = func(msg string) {
+		warned = msg
+	}
+	defer func() {
+		setMiddleMsg = func(string) {}
+	}()
+	for _, c := range []struct {
+		name string
+		fn   func(grid) grid
+		row  string
+		want string
+	}{
+		{"rotate wires", grid.rotateWarning, "-|@", ""},
+		{"rotate", grid.rotateWarning, "1*-L", "Rotated, but 2 work differently now: L *"},
+		{"mirror diodes", grid.mirrorLeftRightWarning, "-<>-", ""},
+		{"mirror", grid.mirrorLeftRightWarning, "1*-L", "Mirrored, but 1 work differently now: *"},
+		{"flip a lamp", grid.mirrorTopBottomWarning, "1*-L", ""},
+		{"flip a delay", grid.mirrorTopBottomWarning, "-D-", "Mirrored, but 1 work differently now: D"},
+		{"flip a relay", grid.mirrorTopBottomWarning, "-S-", "Relays swap between normally open 'S' and normally closed 'Z' when mirrored, 1 changed"},
+	} {
+		g := makeGrid(len(c.row), 1)
+		for x, r := range c.row {
+			g.set(x, 0, r)
+		}
+		warned = ""
+		c.fn(g)
+		if warned != c.want {
+			t.Errorf("%s warned %q, not %q", c.name, warned, c.want)
+		}
+	}
+}
+
+func TestRotateNotSquare(t *testing.T) {
+	b := boardOf("1 -\nX")
+	e := editor{ks: KeysSelecting, selectionRectangle: newRectangle(0, 0, 2, 0)}
+	e.transform(b, grid.rotate)(b)
+	got := string([]rune{b.get(0, 0), b.get(0, 1), b.get(0, 2), b.get(1, 0), b.get(2, 0)})
+	if got != "1X|  " {
+		t.Errorf("rotated to %q", got)
+	}
+	if e.selectionRectangle != newRectangle(0, 0, 0, 2) {
+		t.Errorf("selected %v", e.selectionRectangle)
+	}
+}
+
+func TestTruthSignalsMarked(t *testing.T) {
+	names := func(text string) string {
+		b := makeBoard()
+		for y, line := range strings.Split(text, "\n") {
+			for x, r := range line {
+				b.set(x, y, r)
+			}
+		}
+		inputs, outputs := truthSignals(b, b.extent())
+		found := make([]string, 0)
+		for _, s := range append(inputs, outputs...) {
+			found = append(found, s.name)
+		}
+		return strings.Join(found, " ")
+	}
+	for _, c := range []struct {
+		text string
+		want string
+	}{
+		{"_a_ 0*-L _q_\n    1*-L", "a q"},
+		{"_a_ 0*-L\n    1*-L _q_", "a q"},
+		{"0*-L\n1*-L", "*1,0 *1,1 L3,0 L3,1"},
+		{"_a_ 0*-L\n    1*-L", "a L7,0 L7,1"},
+	} {
+		if got := names(c.text); got != c.want {
+			t.Errorf("%q has the signals %s, not %s", c.text, got, c.want)
+		}
+	}
+}
+
+func TestRecordHeadless(t *testing.T) {
+	dir := t.TempDir()
+	board := filepath.Join(dir, "board.betula")
+	if err := os.WriteFile(board, []byte("1*--E\n1*--B\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	setMiddleMsg, setLeftMsg, beep = nil, nil, nil // as on the command line, until recordMain sets them
+	defer func() {
+		setMiddleMsg, setLeftMsg, beep = func(string) {}, func(string) {}, func() {}
+	}()
+	cast := filepath.Join(dir, "board.cast")
+	if err := recordMain([]string{board, cast, "3"}); err != nil { // the test stops here if the 'E' exits
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(cast); err != nil {
+		t.Error(err)
+	}
+}
+
+// boardOf() - a board drawn from lines of text, the first at y = 0
+func boardOf(text string) board {
+	b := makeBoard()
+	for y, line := range strings.Split(text, "\n") {
+		for x, r := range line {
+			if r != ' ' {
+				b.set(x, y, r)
+			}
+		}
+	}
+	return b
+}
+
+func TestRoute(t *testing.T) {
+	wall := "    |\n    |\n1*  |\n    |\n    |\n"
+	for _, c := range []struct {
+		name  string
+		text  string
+		end   coord
+		lamp  coord  // lit when the route carries the signal
+		wires string // of the route along row 2, when it stays on the row
+	}{
+		{"straight", "\n\n1*", coord{6, 2}, coord{7, 2}, "@---@"},
+		{"crossing", wall, coord{6, 2}, coord{7, 2}, "@-|-@"},
+		{"crossing next to the end", wall, coord{5, 2}, coord{6, 2}, ""},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			b := boardOf(c.text)
+			b.setC(c.lamp, 'L')
+			cells, ok := route(b, coord{2, 2}, c.end)
+			if !ok {
+				if c.wires != "" {
+					t.Fatal("no route")
+				}
+				return // around the wall would run beside the lamp
+			}
+			for _, cell := range cells {
+				b.setC(cell.at, cell.r)
+			}
+			if c.wires != "" {
+				row := make([]rune, 0)
+				for x := 2; x <= c.end.x; x++ {
+					row = append(row, b.get(x, 2))
+				}
+				if string(row) != c.wires {
+					t.Errorf("routed %q, not %q", string(row), c.wires)
+				}
+			}
+			headlessMachine(b).run(3)
+			if v := b.get(c.lamp.x, c.lamp.y-1); v != '1' {
+				t.Errorf("the lamp shows %q", v)
+			}
+		})
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	for _, filename := range []string{"S.betula", "latch.betula", "wolffia.betula"} {
+		b, _, err := loadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := headlessMachine(b)
+		m.run(5)
+		if len(m.warnings.unsettled) > 0 {
+			t.Errorf("%s is unsettled at %v", filename, m.warnings.unsettled)
+		}
+	}
+
+	said := ""
+	setMiddleMsg = func(msg string) {
+		said = msg
+	}
+	defer func() {
+		setMiddleMsg = func(string) {}
+	}()
+	shown, next := makeMachine(makeBoard()), makeMachine(makeBoard())
+	unsettled := warnings{unsettled: []coord{{1, 2}}}
+	wr := warningReport{}
+	wr.report(shown, warnings{})
+	if said != "" {
+		t.Errorf("said %q about a settled board as it was shown", said)
+	}
+	wr.report(shown, unsettled)
+	if said != "Unsettled at 1 2" {
+		t.Errorf("said %q", said)
+	}
+	wr.report(shown, warnings{})
+	if said != "Settled" {
+		t.Errorf("said %q", said)
+	}
+	said = ""
+	wr.report(next, warnings{})
+	if said != "" {
+		t.Errorf("said %q on switching to a settled board", said)
+	}
+}
+
+func TestReplaceAll(t *testing.T) {
+	said := ""
+	setMiddleMsg = func(msg string) {
+		said = msg
+	}
+	defer func() {
+		setMiddleMsg = func(string) {}
+	}()
+	for _, c := range []struct {
+		text, pattern, replacement string
+		want, said                 string
+	}{
+		{"1*--Mspdt--L\n1*--Mspdt", "spdt", "dpdt", "1*--Mdpdt--L\n1*--Mdpdt", "Replaced 2 matches of spdt with dpdt"},
+		{"1*--Mspdt--L\n1*--Mspdt", "spdt", "spdt2", "1*--Mspdt--L\n1*--Mspdt2", "Replaced 1 matches of spdt with spdt2, skipped 1 with no room after them"},
+		{"1*------L", "---", "-", "1*-  -  L", "Replaced 2 matches of --- with -"},
+		{"ab  ab", "ab", "abc", "abc abc", "Replaced 2 matches of ab with abc"},
+		{"abab", "ab", "abc", "ababc", "Replaced 1 matches of ab with abc, skipped 1 with no room after them"},
+	} {
+		b := boardOf(c.text)
+		replaceAll([]rune(c.pattern), []rune(c.replacement), nil)(b)
+		lines := strings.Split(strings.TrimRight(string(b.text(layout{unterminated: true})), "\n"), "\n")
+		for i := range lines {
+			lines[i] = strings.TrimRight(lines[i], " ")
+		}
+		if got := strings.Join(lines, "\n"); got != c.want {
+			t.Errorf("replacing %s with %s in %q made %q, not %q", c.pattern, c.replacement, c.text, got, c.want)
+		}
+		if said != c.said {
+			t.Errorf("said %q, not %q", said, c.said)
+		}
+	}
+}
+
+func TestWirePen(t *testing.T) {
+	for _, c := range []struct {
+		text string
+		path []coord
+		want string
+	}{
+		{"", []coord{{0, 0}, {3, 0}, {3, 2}}, "---@\n   |\n   |"},
+		{"\n\n-----", []coord{{2, 0}, {2, 4}, {4, 4}}, "  |\n  |\n--|--\n  |\n  @--"},
+		{"\n\n-----", []coord{{2, 0}, {2, 2}, {4, 2}}, "  |\n  |\n--|--"},
+		{"  |\n  |\n  |", []coord{{0, 1}, {4, 1}, {4, 2}}, "  |\n--|-@\n  | |"},
+		{"", []coord{{0, 1}, {4, 1}, {4, 0}, {2, 0}, {2, 2}}, "  @-@\n--|-@\n  |"},
+	} {
+		b := boardOf(c.text)
+		var w wirePen
+		w.start(c.path[0])
+		for _, p := range c.path[1:] {
+			for _, cl := range w.to(b, p) {
+				b.setC(cl.at, cl.r)
+			}
+		}
+		lines := strings.Split(strings.TrimRight(string(b.text(layout{unterminated: true})), "\n"), "\n")
+		for i := range lines {
+			lines[i] = strings.TrimRight(lines[i], " ")
+		}
+		if got := strings.Join(lines, "\n"); got != c.want {
+			t.Errorf("drawing %v on %q made\n%s\nnot\n%s", c.path, c.text, got, c.want)
+		}
+	}
+}
+
+func TestExportStyle(t *testing.T) {
+	b := boardOf("1*--@")
+	r := rectangle{coord{0, 0}, coord{4, 0}}
+	for style, want := range map[string]string{"plain": "1*--@", "unicode": "1*──█"} {
+		for _, shown := range []string{"plain", "unicode"} {
+			drawStyle = shown // the board on the screen doesn't change the export
+			got := ""
+			for _, c := range exportCells(b, r, style)[0] {
+				got += string(c.r)
+			}
+			if got != want {
+				t.Errorf("exporting %s with %s on the screen drew %q, not %q", style, shown, got, want)
+			}
+		}
+	}
+	drawStyle = "unicode"
+}
+
+func TestLint(t *testing.T) {
+	for _, c := range []struct {
+		text string
+		want string
+	}{
+		{"1*-|\n   .", "3 1: '.' has only its top input wired and waits forever for the bottom one"},
+		{"/--", "0 0: '/' has no '\\' after it on the row, so the bridge goes nowhere"},
+		{"1*--\\", "4 0: '\\' has no '/' before it on the row, so the bridge goes nowhere"},
+		{"1*--S--L", "4 0: 'S' has nothing wired to its control at 4 -1, so it never switches"},
+		{"1*--~--L", ""},
+		{"~--1*", "0 0: '~' is fed from the right but only passes signals from left to right"},
+		{"Mnosuch", "0 0: there is no file nosuch.betula for the macro nosuch"},
+	} {
+		found := make([]string, 0)
+		for _, p := range lint(boardOf(c.text)) {
+			found = append(found, p.String())
+		}
+		if got := strings.Join(found, "\n"); got != c.want {
+			t.Errorf("linting %q found %q, not %q", c.text, got, c.want)
+		}
+	}
+	b, _, err := loadFile("xor.betula")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := lint(b); len(problems) > 0 {
+		t.Errorf("xor.betula has %v", problems)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"github.com/gdamore/tcell"
+)
+
+// mouse - what the mouse buttons are doing to the board.
+// Left button: click to move the cursor or toggle an input, drag to select.
+// Right button: drag to draw a wire. Middle button: paste. Wheel: scroll.
+type mouse struct {
+	down    tcell.ButtonMask // the buttons held at the last event
+	start   coord            // where the left button went down
+	dragged bool
+	pen     wirePen
+}
+
+var theMouse = mouse{}
+
+const mouseButtons = tcell.Button1 | tcell.Button2 | tcell.Button3
+
+// handle() - a mouse event while editing the board
+func (m *mouse) handle(ev *tcell.EventMouse) {
+	x, y := ev.Position()
+	buttons := ev.Buttons()
+	defer func() {
+		m.down = buttons & mouseButtons
+	}()
+	if buttons&tcell.WheelUp != 0 {
+		theViewport.origin.y -= 3
+		cursorY -= 3
+		return
+	}
+	if buttons&tcell.WheelDown != 0 {
+		theViewport.origin.y += 3
+		cursorY += 3
+		return
+	}
+	if y >= theViewport.height {
+		return // the status line
+	}
+	p := theViewport.toBoard(x, y)
+	pressed := buttons &^ m.down
+	held := buttons & m.down
+	released := m.down &^ buttons
+	switch {
+	case pressed&tcell.Button1 != 0:
+		m.start = p
+		m.dragged = false
+		theEditor.noShift()
+		cursorX, cursorY = p.x, p.y
+	case held&tcell.Button1 != 0:
+		if p != m.start {
+			m.dragged = true
+			theEditor.pivot = m.start
+			theEditor.ks = KeysSelecting
+			theEditor.update(p)
+		}
+		cursorX, cursorY = p.x, p.y
+	case released&tcell.Button1 != 0:
+		if !m.dragged {
+			var input bool
+			snapshot(func(b board, _ int) {
+				input = isInput(b, p)
+			})
+			if input {
+				submit(toggle(p.x, p.y))
+			}
+		}
+	case pressed&tcell.Button3 != 0:
+		m.pen.start(p)
+		cursorX, cursorY = p.x, p.y
+	case held&tcell.Button3 != 0:
+		var cells []cell
+		snapshot(func(b board, _ int) {
+			cells = m.pen.to(b, p)
+		})
+		submit(func(b board) {
+			for _, c := range cells {
+				b.setC(c.at, c.r)
+			}
+		})
+		cursorX, cursorY = p.x, p.y
+	case pressed&tcell.Button2 != 0:
+		submit(theEditor.paste(p))
+	case buttons&mouseButtons == 0 && m.down == 0:
+		theInspector.hovered(p)
+	default:
+	}
+}
+
+// isInput() - is p the value of a constant, the cell to the left of a '*'
+func isInput(b board, p coord) bool {
+	return b.get(p.x+1, p.y) == '*'
+}
+
+// cell - a rune to write at a location
+type cell struct {
+	at coord
+	r  rune
+}
+
+// wirePen - lays down '-' and '|' along the path of the mouse with '@' at the corners.
+// It crosses wires, its own too, with '-|-', only turns with '@' on wire it drew on blanks,
+// and does not overwrite anything else.
+type wirePen struct {
+	last  coord
+	dir   rune // the wire rune of the last step, 0 before the first step
+	drawn map[coord]rune
+	laid  map[coord]bool // the cells drawn on blanks, where a corner can't short another wire
+}
+
+func (w *wirePen) start(p coord) {
+	w.last = p
+	w.dir = 0
+	w.drawn = make(map[coord]rune)
+	w.laid = make(map[coord]bool)
+}
+
+// to() - the cells to write to draw from the last point to p on board b, horizontally then vertically
+func (w *wirePen) to(b board, p coord) []cell {
+	cells := make([]cell, 0)
+	get := func(at coord) rune {
+		if r, ok := w.drawn[at]; ok {
+			return r
+		}
+		return b.getC(at)
+	}
+	put := func(at coord, r rune) {
+		w.drawn[at] = r
+		cells = append(cells, cell{at, r})
+	}
+	step := func(dir rune, next coord) {
+		here := get(w.last)
+		switch {
+		case w.dir == 0:
+			if nonValue(here) {
+				put(w.last, dir)
+				w.laid[w.last] = true
+			}
+		case w.dir != dir && w.laid[w.last]:
+			put(w.last, '@') // corner
+		}
+		switch there := get(next); {
+		case nonValue(there):
+			put(next, dir)
+			w.laid[next] = true
+		case there == '-' && dir == '|':
+			put(next, '|') // vertical wire crossing a horizontal one
+			delete(w.laid, next)
+		default:
+			// keep crossings, junctions and components
+		}
+		w.last = next
+		w.dir = dir
+	}
+	for w.last.x != p.x {
+		dx := 1
+		if p.x < w.last.x {
+			dx = -1
+		}
+		step('-', coord{w.last.x + dx, w.last.y})
+	}
+	for w.last.y != p.y {
+		dy := 1
+		if p.y < w.last.y {
+			dy = -1
+		}
+		step('|', coord{w.last.x, w.last.y + dy})
+	}
+	return cells
+}
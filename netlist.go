@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// A netlist is the board seen as components joined by wires. It is found by following the
+// ways propagate() moves a signal from cell to cell, without running the board.
+
+// gateRunes - the two input gates, inputs top and bottom and the output on the right
+var gateRunes = map[rune]bool{'.': true, '+': true, '#': true, '^': true, '=': true}
+
+// arrival - a signal reaching an input of a component
+type arrival struct {
+	from     coord // the cell next to the component it came from
+	driver   coord // the component whose output it is
+	viaDiode bool  // passed through '>' or '<' on the way, so other signals can join it
+}
+
+// problem - something at a place on the board
+type problem struct {
+	at      coord
+	message string
+}
+
+func (p problem) String() string {
+	return fmt.Sprintf("%d %d: %s", p.at.x, p.at.y, p.message)
+}
+
+type netlist struct {
+	drivers  []coord             // components with an output, in the order they were traced
+	arrivals map[coord][]arrival // the signals reaching each component
+	wires    map[coord][]coord   // the cells each driver's signal passes through
+}
+
+type traceStep struct {
+	from     coord
+	at       coord
+	viaDiode bool
+}
+
+// bridgeEnd() - the '\' which ends the bridge starting with the '/' at p
+func bridgeEnd(b board, p coord) (coord, bool) {
+	for x := p.x + 1; x <= b.bounds().bottomRight.x; x++ {
+		if b.get(x, p.y) == '\\' {
+			return coord{x, p.y}, true
+		}
+	}
+	return coord{}, false
+}
+
+// bridgeStart() - the '/' which starts the bridge ending with the '\' at p
+func bridgeStart(b board, p coord) (coord, bool) {
+	for x := p.x - 1; x >= b.bounds().topLeft.x; x-- {
+		if b.get(x, p.y) == '/' {
+			return coord{x, p.y}, true
+		}
+	}
+	return coord{}, false
+}
+
+// trace() - follow the output of the component at driver into the cells outs, calling pass()
+// for each cell the signal goes through and arrive() at each component it reaches
+func trace(b board, driver coord, outs []coord, pass func(p coord), arrive func(p coord, a arrival)) {
+	visited := map[traceStep]bool{}
+	stack := make([]traceStep, 0, len(outs))
+	for _, out := range outs {
+		stack = append(stack, traceStep{driver, out, false})
+	}
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if visited[s] {
+			continue
+		}
+		visited[s] = true
+		p, f := s.at, s.from
+		left, right, up, down := coord{p.x - 1, p.y}, coord{p.x + 1, p.y}, coord{p.x, p.y - 1}, coord{p.x, p.y + 1}
+		// next() - carry on into the cells given, except back where we came from
+		next := func(viaDiode bool, to ...coord) {
+			for _, t := range to {
+				if t != f {
+					stack = append(stack, traceStep{p, t, viaDiode})
+				}
+			}
+		}
+		switch r := b.getC(p); {
+		case r == '-':
+			pass(p)
+			next(s.viaDiode, left, right)
+		case r == '|':
+			if (f == left || f == right) && b.getC(left) == '-' && b.getC(right) == '-' {
+				pass(p) // crossing under
+				next(s.viaDiode, left, right)
+			} else if f == up || f == down {
+				pass(p)
+				next(s.viaDiode, up, down)
+			}
+		case r == '@':
+			pass(p)
+			next(s.viaDiode, up, down, left, right)
+		case r == '/':
+			if end, ok := bridgeEnd(b, p); ok {
+				pass(p)
+				pass(end)
+				next(s.viaDiode, left, coord{end.x + 1, p.y})
+			}
+		case r == '\\':
+			if start, ok := bridgeStart(b, p); ok {
+				pass(start)
+				pass(p)
+				next(s.viaDiode, coord{start.x - 1, p.y}, right)
+			}
+		case r == 'L' || r == 'J':
+			pass(p)
+			arrive(p, arrival{f, driver, s.viaDiode})
+			next(s.viaDiode, left, right)
+		case r == '>':
+			if f != right {
+				pass(p)
+				next(true, right)
+			}
+		case r == '<':
+			if f != left {
+				pass(p)
+				next(true, left)
+			}
+		case r == '~':
+			if f == left {
+				pass(p)
+				next(s.viaDiode, right)
+			}
+		case gateRunes[r] || r == 'N' || r == 'D' || r == 'S' || r == 'Z' || r == 'E' || r == 'B':
+			arrive(p, arrival{f, driver, s.viaDiode})
+		default:
+			// values, roots and comments stop a signal
+		}
+	}
+}
+
+// eachOutsideComments() - call fn for each cell of b, top to bottom and left to right, except in comments
+func (b board) eachOutsideComments(fn func(p coord, r rune)) {
+	bounds := b.bounds()
+	for y := bounds.topLeft.y; y <= bounds.bottomRight.y; y++ {
+		for x := bounds.topLeft.x; x <= bounds.bottomRight.x; x++ {
+			r := b.get(x, y)
+			if r == '_' {
+				x = b.commentEnd(x+1, y, bounds.bottomRight.x) + 1
+				continue
+			}
+			if !nonValue(r) {
+				fn(coord{x, y}, r)
+			}
+		}
+	}
+}
+
+// makeNetlist() - trace the output of every component on b
+func makeNetlist(b board) *netlist {
+	n := &netlist{arrivals: map[coord][]arrival{}, wires: map[coord][]coord{}}
+	inverters := make([]coord, 0)
+	b.eachOutsideComments(func(p coord, r rune) {
+		switch {
+		case r == '*' || r == 'C' || r == 'R':
+			n.drive(b, p, []coord{{p.x, p.y + 1}, {p.x + 1, p.y}, {p.x, p.y - 1}})
+		case gateRunes[r] || r == 'D':
+			n.drive(b, p, []coord{{p.x + 1, p.y}})
+		case r == 'N':
+			inverters = append(inverters, p)
+		}
+	})
+	// an inverter drives out of the sides its input doesn't come from,
+	// which are only known once whatever drives it has been traced
+	for traced := true; traced; {
+		traced = false
+		for i := 0; i < len(inverters); i++ {
+			p := inverters[i]
+			if len(n.arrivals[p]) == 0 {
+				continue
+			}
+			outs := make([]coord, 0, 4)
+			for _, side := range []coord{{p.x, p.y - 1}, {p.x, p.y + 1}, {p.x + 1, p.y}, {p.x - 1, p.y}} {
+				if len(n.inputs(p, side)) == 0 {
+					outs = append(outs, side)
+				}
+			}
+			n.drive(b, p, outs)
+			inverters = append(inverters[:i], inverters[i+1:]...)
+			i--
+			traced = true
+		}
+	}
+	return n
+}
+
+func (n *netlist) drive(b board, p coord, outs []coord) {
+	n.drivers = append(n.drivers, p)
+	trace(b, p, outs, func(w coord) {
+		n.wires[p] = append(n.wires[p], w)
+	}, func(c coord, a arrival) {
+		n.arrivals[c] = append(n.arrivals[c], a)
+	})
+}
+
+// inputs() - the signals reaching the component at p from the cell from, each driver once
+func (n *netlist) inputs(p coord, from coord) []arrival {
+	found := make([]arrival, 0)
+	seen := map[coord]bool{}
+	for _, a := range n.arrivals[p] {
+		if a.from == from && !seen[a.driver] {
+			seen[a.driver] = true
+			found = append(found, a)
+		}
+	}
+	return found
+}
+
+// components() - the places of the components with inputs or outputs, top to bottom and left to right
+func (n *netlist) components() []coord {
+	seen := map[coord]bool{}
+	for _, p := range n.drivers {
+		seen[p] = true
+	}
+	for p := range n.arrivals {
+		seen[p] = true
+	}
+	return sortedCoords(seen)
+}
+
+// sortedCoords() - the places in set, top to bottom and left to right
+func sortedCoords(set map[coord]bool) []coord {
+	all := make([]coord, 0, len(set))
+	for p := range set {
+		all = append(all, p)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].y != all[j].y {
+			return all[i].y < all[j].y
+		}
+		return all[i].x < all[j].x
+	})
+	return all
+}
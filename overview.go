@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gdamore/tcell"
+)
+
+// overview - a zoomed-out map of the whole board drawn with braille dots.
+// Each screen cell is a 2x4 block of dots, a dot covers scale*2 columns and
+// scale rows of the board so the map keeps the shape of the circuit.
+type overview struct {
+	on     bool
+	cursor coord // screen location of the map cursor
+	area   rectangle
+	scale  int
+}
+
+var theOverview = overview{}
+
+// brailleDots - the bit of each dot in a braille character, indexed [x][y]
+var brailleDots = [2][4]rune{
+	{0x01, 0x02, 0x04, 0x40},
+	{0x08, 0x10, 0x20, 0x80},
+}
+
+// toggle() - enter or leave the overview, the map cursor starts where the board cursor is
+func (o *overview) toggle(b board) {
+	o.on = !o.on
+	if !o.on {
+		return
+	}
+	o.area = b.extent()
+	o.area.topLeft = coord{minInt(o.area.topLeft.x, cursorX), minInt(o.area.topLeft.y, cursorY)}
+	o.area.bottomRight = coord{maxInt(o.area.bottomRight.x, cursorX), maxInt(o.area.bottomRight.y, cursorY)}
+	w := o.area.bottomRight.x - o.area.topLeft.x + 1
+	h := o.area.bottomRight.y - o.area.topLeft.y + 1
+	o.scale = maxInt(maxInt((w+4*theViewport.width-1)/(4*theViewport.width), (h+4*theViewport.height-1)/(4*theViewport.height)), 1)
+	o.cursor = o.toScreen(coord{cursorX, cursorY})
+}
+
+// cellWidth() - the number of board columns in one screen cell of the map
+func (o *overview) cellWidth() int {
+	return 4 * o.scale
+}
+
+// cellHeight() - the number of board rows in one screen cell of the map
+func (o *overview) cellHeight() int {
+	return 4 * o.scale
+}
+
+// toScreen() - the screen location of board location p
+func (o *overview) toScreen(p coord) coord {
+	return coord{
+		(p.x - o.area.topLeft.x) / o.cellWidth(),
+		(p.y - o.area.topLeft.y) / o.cellHeight(),
+	}
+}
+
+// toBoard() - the board location in the middle of the screen cell at x, y
+func (o *overview) toBoard(x, y int) coord {
+	return coord{
+		o.area.topLeft.x + x*o.cellWidth() + o.cellWidth()/2,
+		o.area.topLeft.y + y*o.cellHeight() + o.cellHeight()/2,
+	}
+}
+
+// jump() - leave the overview with the cursor and viewport centred on screen location x, y
+func (o *overview) jump(x, y int) {
+	p := o.toBoard(x, y)
+	cursorX, cursorY = p.x, p.y
+	theViewport.center(p)
+	o.on = false
+}
+
+// handle() - keys while the overview is showing
+func (o *overview) handle(ev *tcell.EventKey) {
+	switch ev.Key() {
+	case tcell.KeyF2, tcell.KeyEscape:
+		o.on = false
+	case tcell.KeyEnter:
+		o.jump(o.cursor.x, o.cursor.y)
+	case tcell.KeyUp:
+		o.cursor.y -= 1
+	case tcell.KeyDown:
+		o.cursor.y += 1
+	case tcell.KeyLeft:
+		o.cursor.x -= 1
+	case tcell.KeyRight:
+		o.cursor.x += 1
+	default:
+	}
+}
+
+// view() - draw the map, lit cells are yellow and the part of the board in the viewport is highlighted
+func (o *overview) view(s tcell.Screen, b board) {
+	v := theViewport
+	shown := rectangle{v.origin, coord{v.origin.x + v.width - 1, v.origin.y + v.height - 1}}
+	dotWidth := o.cellWidth() / 2
+	dotHeight := o.cellHeight() / 4
+	for sy := 0; sy < v.height; sy++ {
+		for sx := 0; sx < v.width; sx++ {
+			var dots rune
+			lit := false
+			inView := false
+			for dx := 0; dx < 2; dx++ {
+				for dy := 0; dy < 4; dy++ {
+					for x := 0; x < dotWidth; x++ {
+						for y := 0; y < dotHeight; y++ {
+							p := coord{
+								o.area.topLeft.x + sx*o.cellWidth() + dx*dotWidth + x,
+								o.area.topLeft.y + sy*o.cellHeight() + dy*dotHeight + y,
+							}
+							r := b.getC(p)
+							if !nonValue(r) {
+								dots |= brailleDots[dx][dy]
+							}
+							if isDigit(r) && !isZero(r) {
+								lit = true
+							}
+							if shown.inside(p) {
+								inView = true
+							}
+						}
+					}
+				}
+			}
+			glyph := ' '
+			if dots != 0 {
+				if drawStyle == "unicode" {
+					glyph = 0x2800 + dots
+				} else {
+					glyph = ':'
+				}
+			}
+			sty := tcell.StyleDefault.Foreground(tcell.ColorLightBlue)
+			if lit {
+				sty = sty.Foreground(tcell.ColorYellow)
+			}
+			if inView {
+				sty = sty.Background(tcell.ColorDarkSlateGray)
+			}
+			if sx == o.cursor.x && sy == o.cursor.y {
+				sty = sty.Reverse(true)
+			}
+			s.SetContent(sx, sy, glyph, nil, sty)
+		}
+	}
+	for sx := 0; sx < v.width; sx++ {
+		s.SetContent(sx, v.height, ' ', nil, tcell.StyleDefault)
+	}
+	p := o.toBoard(o.cursor.x, o.cursor.y)
+	setLeftMsg(fmt.Sprintf("overview 1:%d %3d %3d - Enter to jump, Esc to return", o.cellWidth(), p.x, p.y))
+}
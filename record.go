@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// recording - the board drawn as view() draws it every so many ticks, written as an
+// animated GIF or an asciicast v2 file for asciinema
+type recording struct {
+	filename string
+	region   rectangle
+	style    string // the render style, fixed when the recording starts
+	every    int
+	elapsed  time.Duration // of the run so far, at the clock speed of each tick
+	frames   []frame
+}
+
+type frame struct {
+	at    time.Duration
+	cells [][]exportCell
+}
+
+// recordLimit - frames kept before a recording stops growing, about a minute at the default speed
+const recordLimit = 1200
+
+func recordingFormat(filename string) (string, error) {
+	switch ext := strings.ToLower(filepath.Ext(filename)); ext {
+	case ".gif", ".cast":
+		return ext, nil
+	}
+	return "", fmt.Errorf("can only record to .gif or .cast files, not %s", filename)
+}
+
+func newRecording(filename string, region rectangle, style string, every int) (*recording, error) {
+	if _, err := recordingFormat(filename); err != nil {
+		return nil, err
+	}
+	if region.bottomRight.x < region.topLeft.x || region.bottomRight.y < region.topLeft.y {
+		return nil, fmt.Errorf("nothing to record")
+	}
+	if every < 1 {
+		return nil, fmt.Errorf("record every 1 tick or more, not %d", every)
+	}
+	return &recording{filename: filename, region: region, style: style, every: every}, nil
+}
+
+// capture() - keep a frame of b when it is one to keep, called after each tick which took speed
+func (rc *recording) capture(b board, ticks int, speed time.Duration) {
+	rc.elapsed += speed
+	if ticks%rc.every != 0 || len(rc.frames) >= recordLimit {
+		return
+	}
+	rc.frames = append(rc.frames, frame{rc.elapsed, exportCells(b, rc.region, rc.style)})
+}
+
+// write() - save the frames in the format of the filename
+func (rc *recording) write() error {
+	if len(rc.frames) == 0 {
+		return fmt.Errorf("no frames recorded for %s", rc.filename)
+	}
+	var data bytes.Buffer
+	format, _ := recordingFormat(rc.filename)
+	var err error
+	switch format {
+	case ".gif":
+		err = writeGIF(&data, rc.frames)
+	case ".cast":
+		err = writeAsciicast(&data, rc.frames)
+	}
+	if err != nil {
+		return err
+	}
+	return replaceFile(rc.filename, data.Bytes())
+}
+
+// delays() - how long each frame shows, the last one as long as the one before it
+func delays(frames []frame) []time.Duration {
+	d := make([]time.Duration, len(frames))
+	for i := range frames {
+		if i+1 < len(frames) {
+			d[i] = frames[i+1].at - frames[i].at
+		} else if i > 0 {
+			d[i] = d[i-1]
+		} else {
+			d[i] = time.Second
+		}
+	}
+	return d
+}
+
+// writeGIF() - the frames drawn like a PNG export, with a palette of the colours they use
+func writeGIF(w io.Writer, frames []frame) error {
+	palette := color.Palette{}
+	seen := map[color.RGBA]bool{}
+	for _, f := range frames {
+		for _, row := range f.cells {
+			for _, c := range row {
+				for _, rgb := range []color.RGBA{c.fg, c.bg} {
+					if !seen[rgb] && len(palette) < 256 {
+						seen[rgb] = true
+						palette = append(palette, rgb)
+					}
+				}
+			}
+		}
+	}
+	anim := gif.GIF{}
+	for i, d := range delays(frames) {
+		img := drawPNG(frames[i].cells)
+		p := image.NewPaletted(img.Bounds(), palette)
+		draw.Draw(p, p.Bounds(), img, image.Point{}, draw.Src)
+		anim.Image = append(anim.Image, p)
+		anim.Delay = append(anim.Delay, maxInt(2, int(d/(10*time.Millisecond)))) // 100ths of a second, browsers ignore less than 2
+	}
+	return gif.EncodeAll(w, &anim)
+}
+
+// writeAsciicast() - the frames as a terminal would show them, in 24 bit colour
+func writeAsciicast(w io.Writer, frames []frame) error {
+	width, height := len(frames[0].cells[0]), len(frames[0].cells)
+	header, err := json.Marshal(map[string]interface{}{
+		"version":   2,
+		"width":     width,
+		"height":    height,
+		"timestamp": time.Now().Unix(),
+		"env":       map[string]string{"TERM": "xterm-256color"},
+	})
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	out.Write(header)
+	out.WriteByte('\n')
+	for i, f := range frames {
+		var screen strings.Builder
+		if i == 0 {
+			screen.WriteString("\x1b[2J")
+		}
+		screen.WriteString("\x1b[H")
+		for y, row := range f.cells {
+			if y > 0 {
+				screen.WriteString("\r\n")
+			}
+			last := ""
+			for _, c := range row {
+				bold := 22 // normal intensity
+				if c.bold {
+					bold = 1
+				}
+				sgr := fmt.Sprintf("\x1b[%d;38;2;%d;%d;%d;48;2;%d;%d;%dm", bold, c.fg.R, c.fg.G, c.fg.B, c.bg.R, c.bg.G, c.bg.B)
+				if sgr != last {
+					screen.WriteString(sgr)
+					last = sgr
+				}
+				screen.WriteRune(c.r)
+			}
+			screen.WriteString("\x1b[0m")
+		}
+		event, err := json.Marshal([]interface{}{f.at.Seconds() - frames[0].at.Seconds(), "o", screen.String()})
+		if err != nil {
+			return err
+		}
+		out.Write(event)
+		out.WriteByte('\n')
+	}
+	_, err = w.Write(out.Bytes())
+	return err
+}
+
+// commandRecord() - :record file [every] starts recording the selection or else the board
+// as it is now, and :record stop writes the file
+func commandRecord(args []string) error {
+	bu := current()
+	m := bu.m
+	if len(args) == 1 && args[0] == "stop" {
+		m.submit(func(b board) {
+			rc := m.recording
+			if rc == nil {
+				setMiddleMsg("Not recording")
+				return
+			}
+			m.recording = nil
+			if err := rc.write(); err != nil {
+				setMiddleMsg(err.Error())
+				return
+			}
+			setMiddleMsg(fmt.Sprintf("Recorded %d frames to %s", len(rc.frames), rc.filename))
+		})
+		return nil
+	}
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("usage: record file.gif|file.cast [every], record stop")
+	}
+	every := 1
+	if len(args) == 2 {
+		var err error
+		if every, err = parseTicks(args[1]); err != nil {
+			return err
+		}
+	}
+	var r rectangle
+	snapshot(func(b board, _ int) {
+		r = b.extent()
+	})
+	if theEditor.ks != KeysNormal {
+		r = theEditor.selectionRectangle
+	}
+	rc, err := newRecording(args[0], r, bu.layout.settings.renderStyle(), every)
+	if err != nil {
+		return err
+	}
+	m.submit(func(b board) {
+		if m.recording != nil {
+			setMiddleMsg(fmt.Sprintf("Already recording to %s", m.recording.filename))
+			return
+		}
+		m.recording = rc
+		setMiddleMsg(fmt.Sprintf("Recording to %s, :record stop to finish", rc.filename))
+	})
+	return nil
+}
+
+// recordMain() - betula record board.betula file.gif|file.cast ticks [every], without the editor
+func recordMain(args []string) error {
+	if len(args) < 3 || len(args) > 4 {
+		return fmt.Errorf("usage: betula record board.betula file.gif|file.cast ticks [every]")
+	}
+	ticks, err := parseTicks(args[2])
+	if err != nil {
+		return err
+	}
+	every := 1
+	if len(args) == 4 {
+		if every, err = parseTicks(args[3]); err != nil {
+			return err
+		}
+	}
+	headlessInit()
+	b, l, err := loadFile(args[0])
+	if err != nil {
+		return err
+	}
+	m := headlessMachine(b)
+	l.settings.apply(m)
+	if m.recording, err = newRecording(args[1], b.extent(), l.settings.renderStyle(), every); err != nil {
+		return err
+	}
+	m.run(ticks)
+	return m.recording.write()
+}
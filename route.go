@@ -0,0 +1,234 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"github.com/gdamore/tcell"
+)
+
+// router - lays an orthogonal wire between two marked cells around whatever is on the board.
+// The wire never runs next to other cells, where it would pick up or leak signals,
+// but it can cross a straight horizontal wire as '-|-' and a vertical wire the same way.
+// A crossing only works with straight wire on both sides, so never next to a corner or an end.
+type router struct {
+	marked bool
+	start  coord
+}
+
+var theRouter = router{}
+
+// routeTurnCost - the extra cost of a corner, so routes prefer long straight runs
+const routeTurnCost = 4
+
+// routeMargin - how far outside the circuit a route may wander
+const routeMargin = 3
+
+var routeDirections = []coord{{1, 0}, {-1, 0}, {0, 1}, {0, -1}}
+
+// mark() - the first call marks the start at p, the second returns the edit which routes from there to p
+func (ro *router) mark(b board, p coord) edit {
+	if !ro.marked {
+		ro.marked = true
+		ro.start = p
+		setMiddleMsg(fmt.Sprintf("Route from %d %d - move to the end and mark it", p.x, p.y))
+		return nil
+	}
+	ro.marked = false
+	cells, ok := route(b, ro.start, p)
+	if !ok {
+		setMiddleMsg(fmt.Sprintf("No route from %d %d to %d %d", ro.start.x, ro.start.y, p.x, p.y))
+		return nil
+	}
+	setMiddleMsg(fmt.Sprintf("Routed %d cells from %d %d to %d %d", len(cells), ro.start.x, ro.start.y, p.x, p.y))
+	return func(b board) {
+		for _, c := range cells {
+			b.setC(c.at, c.r)
+		}
+	}
+}
+
+// cancel() - forget the start mark
+func (ro *router) cancel() {
+	ro.marked = false
+}
+
+// markStart() - show where the route starts
+func (ro *router) markStart(m marks) {
+	if ro.marked {
+		m[ro.start] = tcell.ColorGreen
+	}
+}
+
+type routeState struct {
+	at  coord
+	dir int // index into routeDirections, -1 at the start
+}
+
+type routeItem struct {
+	state routeState
+	cost  int
+}
+
+type routeQueue []routeItem
+
+func (q routeQueue) Len() int            { return len(q) }
+func (q routeQueue) Less(i, j int) bool  { return q[i].cost < q[j].cost }
+func (q routeQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *routeQueue) Push(x interface{}) { *q = append(*q, x.(routeItem)) }
+func (q *routeQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// crossable() - can a wire going in direction d pass straight through the existing wire at p
+func crossable(b board, p coord, d coord) bool {
+	switch b.getC(p) {
+	case '-':
+		return d.x == 0 && b.get(p.x-1, p.y) == '-' && b.get(p.x+1, p.y) == '-'
+	case '|':
+		return d.y == 0 && b.get(p.x, p.y-1) == '|' && b.get(p.x, p.y+1) == '|'
+	}
+	return false
+}
+
+// route() - the cells to write for the cheapest wire from start to end on board b
+func route(b board, start coord, end coord) ([]cell, bool) {
+	return routeFrom(b, []coord{start}, end)
+}
+
+// routeFrom() - the cheapest wire from whichever of starts is nearest to end, for
+// joining a wire which already carries the signal. The first cell is the start used.
+func routeFrom(b board, starts []coord, end coord) ([]cell, bool) {
+	area := b.extent()
+	area.topLeft = coord{minInt(area.topLeft.x, end.x) - routeMargin, minInt(area.topLeft.y, end.y) - routeMargin}
+	area.bottomRight = coord{maxInt(area.bottomRight.x, end.x) + routeMargin, maxInt(area.bottomRight.y, end.y) + routeMargin}
+	isStart := map[coord]bool{}
+	for _, start := range starts {
+		isStart[start] = true
+		area.topLeft = coord{minInt(area.topLeft.x, start.x-routeMargin), minInt(area.topLeft.y, start.y-routeMargin)}
+		area.bottomRight = coord{maxInt(area.bottomRight.x, start.x+routeMargin), maxInt(area.bottomRight.y, start.y+routeMargin)}
+	}
+
+	// occupied() - the directions from p to cells with something in them, other than from and the ends
+	occupied := func(p coord, from coord) []int {
+		dirs := make([]int, 0)
+		for i, d := range routeDirections {
+			n := coord{p.x + d.x, p.y + d.y}
+			if n == from || isStart[n] || n == end {
+				continue
+			}
+			if !nonValue(b.getC(n)) {
+				dirs = append(dirs, i)
+			}
+		}
+		return dirs
+	}
+
+	costs := map[routeState]int{}
+	previous := map[routeState]routeState{}
+	q := &routeQueue{}
+	for _, start := range starts {
+		first := routeState{start, -1}
+		costs[first] = 0
+		heap.Push(q, routeItem{first, 0})
+	}
+	var found *routeState
+	for q.Len() > 0 {
+		item := heap.Pop(q).(routeItem)
+		s := item.state
+		if item.cost > costs[s] {
+			continue
+		}
+		if s.at == end {
+			found = &s
+			break
+		}
+		// which ways can we go from here
+		allowed := []int{0, 1, 2, 3}
+		if s.dir != -1 {
+			from := previous[s].at
+			if !nonValue(b.getC(s.at)) {
+				allowed = []int{s.dir} // straight on through a crossing
+			} else if busy := occupied(s.at, from); len(busy) > 1 {
+				continue // next to more than one thing
+			} else if len(busy) == 1 {
+				allowed = busy // only if it is a wire we can cross
+			}
+			if !isStart[from] && !nonValue(b.getC(from)) {
+				// out of a crossing, on in a straight line
+				if len(allowed) == 1 && allowed[0] != s.dir {
+					continue
+				}
+				allowed = []int{s.dir}
+			}
+		}
+		for _, i := range allowed {
+			d := routeDirections[i]
+			if s.dir != -1 && d.x == -routeDirections[s.dir].x && d.y == -routeDirections[s.dir].y {
+				continue // no going back
+			}
+			n := coord{s.at.x + d.x, s.at.y + d.y}
+			if !area.inside(n) {
+				continue
+			}
+			if n != end && !nonValue(b.getC(n)) {
+				after := coord{n.x + d.x, n.y + d.y}
+				switch {
+				case !crossable(b, n, d):
+					continue
+				case s.dir != i || !nonValue(b.getC(s.at)):
+					continue // into a crossing from a corner, an end or another crossing
+				case after == end || isStart[after]:
+					continue // out of a crossing into an end
+				}
+			}
+			cost := item.cost + 1
+			if s.dir != -1 && s.dir != i {
+				cost += routeTurnCost
+			}
+			next := routeState{n, i}
+			if c, ok := costs[next]; ok && c <= cost {
+				continue
+			}
+			costs[next] = cost
+			previous[next] = s
+			heap.Push(q, routeItem{next, cost})
+		}
+	}
+	if found == nil {
+		return nil, false
+	}
+
+	path := []routeState{*found}
+	for s := *found; s.dir != -1; {
+		s = previous[s]
+		path = append([]routeState{s}, path...)
+	}
+	cells := make([]cell, 0, len(path))
+	for i, s := range path {
+		r := b.getC(s.at)
+		switch {
+		case i == 0 || s.at == end:
+			if nonValue(r) {
+				r = '@'
+			}
+		case !nonValue(r):
+			r = '|' // crossing a wire, '-' becomes -|- and '|' stays as it is
+		case path[i+1].dir != s.dir:
+			r = '@' // corner
+		default:
+			r = wireRune(s.dir)
+		}
+		cells = append(cells, cell{s.at, r})
+	}
+	return cells, true
+}
+
+func wireRune(dir int) rune {
+	if routeDirections[dir].x != 0 {
+		return '-'
+	}
+	return '|'
+}
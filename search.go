@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gdamore/tcell"
+)
+
+// search - find a string of runes along the rows of the board and optionally replace it.
+// While a selection is active the search is limited to the selection rectangle.
+type search struct {
+	on          bool // typing into the prompt
+	replacing   bool
+	input       prompt
+	pattern     []rune
+	replacement []rune
+	within      *rectangle
+}
+
+var theSearch = search{}
+
+// find() - prompt for a string to find
+func (se *search) find() {
+	se.start(false, "find: ")
+}
+
+// replace() - prompt for a string to find then the string to replace it with
+func (se *search) replace() {
+	se.start(true, "replace: ")
+}
+
+func (se *search) start(replacing bool, label string) {
+	se.on = true
+	se.replacing = replacing
+	se.pattern = nil
+	se.replacement = nil
+	se.input = prompt{label: label}
+	se.within = nil
+	if theEditor.ks == KeysSelecting {
+		r := theEditor.selectionRectangle
+		se.within = &r
+	}
+}
+
+// clear() - forget the pattern so nothing is highlighted
+func (se *search) clear() {
+	se.pattern = nil
+	se.within = nil
+}
+
+// handle() - keys while typing into the prompt
+func (se *search) handle(ev *tcell.EventKey) {
+	switch ev.Key() {
+	case tcell.KeyEscape:
+		se.on = false
+		se.clear()
+	case tcell.KeyEnter:
+		if se.replacing && se.pattern != nil {
+			// an empty replacement blanks the matches
+			se.on = false
+			se.replacement = se.input.text
+			submit(replaceAll(se.pattern, se.replacement, se.within))
+			se.pattern = se.replacement
+			return
+		}
+		if len(se.input.text) == 0 {
+			return
+		}
+		if se.replacing {
+			se.pattern = se.input.text
+			se.input = prompt{label: fmt.Sprintf("replace %s with: ", string(se.pattern))}
+			return
+		}
+		se.on = false
+		se.pattern = se.input.text
+		snapshot(func(b board, _ int) {
+			se.next(b)
+		})
+	default:
+		se.input.handle(ev)
+	}
+}
+
+// area() - the part of board b to search
+func (se *search) area(b board) rectangle {
+	if se.within != nil {
+		return *se.within
+	}
+	return b.extent()
+}
+
+// matches() - the locations of the first rune of each match of pattern in rectangle r of board b,
+// row by row. Matches on a row don't overlap.
+func matches(b board, pattern []rune, r rectangle) []coord {
+	found := make([]coord, 0)
+	if len(pattern) == 0 {
+		return found
+	}
+	for y := r.topLeft.y; y <= r.bottomRight.y; y++ {
+		for x := r.topLeft.x; x+len(pattern)-1 <= r.bottomRight.x; x++ {
+			matched := true
+			for i, pr := range pattern {
+				if b.get(x+i, y) != pr {
+					matched = false
+					break
+				}
+			}
+			if matched {
+				found = append(found, coord{x, y})
+				x += len(pattern) - 1
+			}
+		}
+	}
+	return found
+}
+
+// next() - move the cursor to the next match after it, wrapping around to the first
+func (se *search) next(b board) {
+	if len(se.pattern) == 0 {
+		return
+	}
+	found := matches(b, se.pattern, se.area(b))
+	if len(found) == 0 {
+		setMiddleMsg(fmt.Sprintf("Not found: %s", string(se.pattern)))
+		return
+	}
+	p := found[0]
+	for _, m := range found {
+		if m.y > cursorY || (m.y == cursorY && m.x > cursorX) {
+			p = m
+			break
+		}
+	}
+	cursorX, cursorY = p.x, p.y
+	setMiddleMsg(fmt.Sprintf("%d matches of %s", len(found), string(se.pattern)))
+}
+
+// replaceAll() - the edit which overwrites each match of pattern with replacement.
+// A shorter replacement is padded with blanks. A longer one only goes where the cells after
+// the match are blank, so that it never rewires what is beside it.
+func replaceAll(pattern []rune, replacement []rune, within *rectangle) edit {
+	return func(b board) {
+		r := b.extent()
+		if within != nil {
+			r = *within
+		}
+		found := matches(b, pattern, r)
+		replaced := 0
+		for _, m := range found {
+			fits := true
+			for i := len(pattern); i < len(replacement); i++ {
+				if !nonValue(b.get(m.x+i, m.y)) {
+					fits = false
+				}
+			}
+			if !fits {
+				continue
+			}
+			for i := 0; i < maxInt(len(pattern), len(replacement)); i++ {
+				v := ' '
+				if i < len(replacement) {
+					v = replacement[i]
+				}
+				b.set(m.x+i, m.y, v)
+			}
+			replaced++
+		}
+		msg := fmt.Sprintf("Replaced %d matches of %s with %s", replaced, string(pattern), string(replacement))
+		if skipped := len(found) - replaced; skipped > 0 {
+			msg += fmt.Sprintf(", skipped %d with no room after them", skipped)
+		}
+		setMiddleMsg(msg)
+	}
+}
+
+// highlights() - the cells of board b covered by a match
+func (se *search) highlights(b board) map[coord]bool {
+	cells := make(map[coord]bool)
+	if se.pattern == nil || se.on {
+		return cells
+	}
+	for _, m := range matches(b, se.pattern, se.area(b)) {
+		for i := range se.pattern {
+			cells[coord{m.x + i, m.y}] = true
+		}
+	}
+	return cells
+}
+
+// mark() - highlight the matches on b the same way the editor highlights a selection
+func (se *search) mark(b board, m marks) {
+	for p := range se.highlights(b) {
+		m[p] = tcell.ColorYellow
+	}
+}
+
+// view() - show the prompt on the status line
+func (se *search) view(s tcell.Screen) {
+	v := theViewport
+	for x := 0; x < v.width; x++ {
+		s.SetContent(x, v.height, ' ', nil, tcell.StyleDefault)
+	}
+	setLeftMsg(se.input.String())
+	s.SetContent(len([]rune(se.input.String())), v.height, ' ', nil, tcell.StyleDefault.Reverse(true))
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gdamore/tcell"
+	"hash/fnv"
+	"strings"
+)
+
+// A tick which ends with gates still waiting for their inputs after the last of the
+// multiPass passes has not settled, and a board whose inputs stay the same but which
+// keeps coming back to the same few states is oscillating. Both are noticed as the board
+// runs, highlighted on the board and shown on the status line when they change on the
+// board on the screen.
+
+// oscillationPeriod - the longest cycle of states looked for. A cycle is only seen once
+// it has repeated for the whole history kept, as delays have state which is not on the board.
+const oscillationPeriod = 8
+const historyLength = 2 * oscillationPeriod
+
+// settleLimit - ticks to wait for a board to settle before giving up
+const settleLimit = 1000
+
+// warnings - what was noticed about the last tick
+type warnings struct {
+	unsettled   []coord // components still waiting for inputs when the tick ended
+	oscillating []coord // cells changing in a cycle of states
+	period      int     // of the cycle, 0 when not oscillating
+}
+
+// state - the cells of the board at the end of a tick
+type state struct {
+	origin coord
+	cells  grid
+	sum    uint64 // to tell most states apart quickly
+}
+
+func makeState(b board) state {
+	r := b.bounds()
+	s := state{origin: r.topLeft, cells: b.gridOf(r)}
+	h := fnv.New64a()
+	for _, column := range s.cells {
+		_, _ = h.Write([]byte(string(column)))
+	}
+	s.sum = h.Sum64()
+	return s
+}
+
+func (g grid) equal(h grid) bool {
+	if len(g) != len(h) {
+		return false
+	}
+	for x := range g {
+		if string(g[x]) != string(h[x]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s state) equal(t state) bool {
+	return s.sum == t.sum && s.origin == t.origin && s.cells.equal(t.cells)
+}
+
+// differences() - add the cells which are not the same in s and t to diff
+func (s state) differences(t state, diff map[coord]bool) {
+	if s.origin != t.origin || len(s.cells) != len(t.cells) {
+		return // the board grew, the next cycle will show what changes
+	}
+	for x := range s.cells {
+		for y := range s.cells[x] {
+			if y < len(t.cells[x]) && s.cells[x][y] != t.cells[x][y] {
+				diff[coord{s.origin.x + x, s.origin.y + y}] = true
+			}
+		}
+	}
+}
+
+// unsettledAt() - what was still waiting on b when the passes of a tick ran out. Not relays,
+// as relay circuits say 0 with nothing on a wire, so a relay waits for it in every tick.
+func unsettledAt(b board, multiPass visitors) []coord {
+	set := map[coord]bool{}
+	for p := range multiPass {
+		if r := b.getC(p); r != 'S' && r != 'Z' {
+			set[p] = true
+		}
+	}
+	return sortedCoords(set)
+}
+
+// watch() - look for a cycle in the states of b, which is only meaningful while nothing
+// but the board itself changes it, so not with clocks or random numbers or after an edit
+func (m *machine) watch(b board, varying bool) {
+	m.warnings.oscillating, m.warnings.period = nil, 0
+	if varying {
+		m.history = nil
+		return
+	}
+	m.history = append(m.history, makeState(b))
+	if len(m.history) > historyLength {
+		m.history = m.history[1:]
+	}
+	h := m.history
+	// repeats() - has every state kept come back after period ticks
+	repeats := func(period int) bool {
+		for i := period; i < len(h); i++ {
+			if !h[i].equal(h[i-period]) {
+				return false
+			}
+		}
+		return true
+	}
+	for period := 1; 2*period <= len(h); period++ {
+		if !repeats(period) {
+			continue
+		}
+		if period == 1 {
+			return // not changing
+		}
+		changing := map[coord]bool{}
+		for i := len(h) - period; i < len(h); i++ {
+			h[i].differences(h[i-1], changing)
+		}
+		m.warnings.oscillating, m.warnings.period = sortedCoords(changing), period
+		return
+	}
+}
+
+func (w warnings) equal(v warnings) bool {
+	return w.period == v.period && fmt.Sprint(w.unsettled, w.oscillating) == fmt.Sprint(v.unsettled, v.oscillating)
+}
+
+func coordList(cs []coord) string {
+	const most = 4
+	places := make([]string, 0, most)
+	for i, p := range cs {
+		if i == most {
+			places = append(places, fmt.Sprintf("and %d more", len(cs)-most))
+			break
+		}
+		places = append(places, fmt.Sprintf("%d %d", p.x, p.y))
+	}
+	return strings.Join(places, ", ")
+}
+
+// warningReport - the warnings last put on the status line and the machine they were about
+type warningReport struct {
+	m *machine
+	w warnings
+}
+
+var theWarningReport = warningReport{}
+
+// report() - put the warnings w of m on the status line when they change, from view() so
+// that only the board on the screen says anything
+func (wr *warningReport) report(m *machine, w warnings) {
+	if wr.m == m && w.equal(wr.w) {
+		return
+	}
+	switched := wr.m != m
+	wr.m, wr.w = m, w
+	if msg := w.message(); msg != "" {
+		setMiddleMsg(msg)
+	} else if !switched {
+		setMiddleMsg("Settled")
+	}
+}
+
+// message() - the warnings for the status line
+func (w warnings) message() string {
+	found := make([]string, 0, 2)
+	if len(w.unsettled) > 0 {
+		found = append(found, "Unsettled at "+coordList(w.unsettled))
+	}
+	if w.period > 0 {
+		found = append(found, fmt.Sprintf("Oscillating every %d ticks at %s", w.period, coordList(w.oscillating)))
+	}
+	return strings.Join(found, " - ")
+}
+
+// mark() - highlight the cells warned about
+func (w warnings) mark(m marks) {
+	for _, o := range w.oscillating {
+		m[o] = tcell.ColorDarkMagenta
+	}
+	for _, u := range w.unsettled {
+		m[u] = tcell.ColorDarkRed
+	}
+}
+
+// settle() - run m until its board b stays the same for long enough, returning the ticks run
+func (m *machine) settle(b board) (int, bool) {
+	window := settleWindow(b)
+	before := b.gridOf(b.bounds())
+	same := 0
+	for ticks := 1; ticks <= settleLimit; ticks++ {
+		m.step()
+		after := b.gridOf(b.bounds())
+		if after.equal(before) {
+			same++
+		} else {
+			same = 0
+		}
+		if same >= window {
+			return ticks, true
+		}
+		before = after
+	}
+	return settleLimit, false
+}
+
+// settleWindow() - ticks a board must stay the same before it is settled, longer than its longest delay
+func settleWindow(b board) int {
+	lag := 0
+	b.each(func(p coord, r rune) {
+		if r == 'D' {
+			lag = maxInt(lag, rune2Int(b.get(p.x, p.y-1)))
+		}
+	})
+	return lag + 2
+}
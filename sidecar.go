@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// settings - what travels with a board besides its cells, kept beside name.betula in name.betula.toml
+// so that the board file stays a plain grid which older versions still read. For example
+//
+//	version = 1
+//	clockSpeed = "20ms"
+//	renderStyle = "plain"
+//	seed = 42
+//
+// which :speed, :style and :seed change. Only this much of TOML is understood, keys set at
+// the top of the file before any [table]. Everything else, like comments, tables, keys betula
+// doesn't use such as probes = [[3, 4]] and lines which aren't TOML at all, is only kept and
+// written back as it was.
+type settings struct {
+	lines []string
+}
+
+// settingsVersion - the version written to new sidecars, newer ones are read as well as we can
+const settingsVersion = 1
+
+var settingLine = regexp.MustCompile(`^\s*([A-Za-z0-9_-]+)\s*=\s*(.*?)\s*$`)
+
+// tableLine - a [table] or [[array of tables]] header, the keys after it belong to the table
+var tableLine = regexp.MustCompile(`^\s*\[\[?\s*[A-Za-z0-9_."' -]+\s*\]\]?\s*$`)
+
+func sidecarName(filename string) string {
+	return filename + ".toml"
+}
+
+// loadSettings() - read the sidecar of filename, there may not be one
+func loadSettings(filename string) (settings, error) {
+	text, err := os.ReadFile(sidecarName(filename))
+	if os.IsNotExist(err) {
+		return settings{}, nil
+	}
+	if err != nil {
+		return settings{}, err
+	}
+	s := settings{lines: strings.Split(strings.TrimSuffix(string(text), "\n"), "\n")}
+	if _, unknown, _ := s.keyLines(); len(unknown) > 0 {
+		setMiddleMsg(fmt.Sprintf("%s line %d isn't key = value, kept as it is", sidecarName(filename), unknown[0]+1))
+	}
+	if v, ok := s.int("version"); ok && v > settingsVersion {
+		setMiddleMsg(fmt.Sprintf("%s is version %d, newer than this betula", sidecarName(filename), v))
+	}
+	return s, nil
+}
+
+// outsideStrings() - call fn with each rune of line and where it is, except those in strings,
+// until fn returns false
+func outsideStrings(line string, fn func(i int, r rune) bool) {
+	quote, escaped := rune(0), false
+	for i, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case !fn(i, r):
+			return
+		}
+	}
+}
+
+// splitComment() - line before its # comment and the comment with the space before it
+func splitComment(line string) (string, string) {
+	end := len(line)
+	outsideStrings(line, func(i int, r rune) bool {
+		if r == '#' {
+			end = i
+		}
+		return r != '#'
+	})
+	code := strings.TrimRight(line[:end], " \t")
+	return code, line[len(code):]
+}
+
+// brackets() - how many more arrays line opens than it closes
+func brackets(line string) int {
+	n := 0
+	code, _ := splitComment(line)
+	outsideStrings(code, func(_ int, r rune) bool {
+		switch r {
+		case '[':
+			n++
+		case ']':
+			n--
+		}
+		return true
+	})
+	return n
+}
+
+// keyLines() - the lines of s setting keys at the top of the file, those which aren't
+// understood, and where the tables begin, after the last line when there are none
+func (s settings) keyLines() (keys []int, unknown []int, tables int) {
+	depth := 0 // of the brackets of an array written over several lines
+	for i, line := range s.lines {
+		code, _ := splitComment(line)
+		switch {
+		case depth > 0:
+			depth += brackets(line)
+		case strings.TrimSpace(code) == "":
+		case tableLine.MatchString(code):
+			return keys, unknown, i
+		case settingLine.MatchString(code):
+			keys = append(keys, i)
+			depth = brackets(line)
+		default:
+			unknown = append(unknown, i)
+		}
+	}
+	return keys, unknown, len(s.lines)
+}
+
+func (s settings) empty() bool {
+	return len(s.lines) == 0
+}
+
+// find() - the line setting key and its value as it is written in the file
+func (s settings) find(key string) (int, string, bool) {
+	keys, _, _ := s.keyLines()
+	for _, i := range keys {
+		code, _ := splitComment(s.lines[i])
+		if m := settingLine.FindStringSubmatch(code); m[1] == key {
+			return i, m[2], true
+		}
+	}
+	return 0, "", false
+}
+
+// get() - the value of key as it is written in the file
+func (s settings) get(key string) (string, bool) {
+	_, value, ok := s.find(key)
+	return value, ok
+}
+
+// set() - a copy of s with key set to value, which must already be written as TOML. A new
+// key goes after the others, before any tables.
+func (s settings) set(key string, value string) settings {
+	lines := make([]string, len(s.lines), len(s.lines)+2)
+	copy(lines, s.lines)
+	if len(lines) == 0 {
+		lines = append(lines, fmt.Sprintf("version = %d", settingsVersion))
+	}
+	if i, _, ok := (settings{lines}).find(key); ok {
+		_, comment := splitComment(lines[i])
+		lines[i] = fmt.Sprintf("%s = %s%s", key, value, comment)
+		return settings{lines}
+	}
+	_, _, at := (settings{lines}).keyLines()
+	for at > 0 && strings.TrimSpace(lines[at-1]) == "" {
+		at--
+	}
+	lines = append(lines[:at], append([]string{fmt.Sprintf("%s = %s", key, value)}, lines[at:]...)...)
+	return settings{lines}
+}
+
+func (s settings) string(key string) (string, bool) {
+	v, ok := s.get(key)
+	if !ok {
+		return "", false
+	}
+	unquoted, err := strconv.Unquote(v)
+	return unquoted, err == nil
+}
+
+func (s settings) int(key string) (int64, bool) {
+	v, ok := s.get(key)
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	return n, err == nil
+}
+
+func (s settings) duration(key string) (time.Duration, bool) {
+	v, ok := s.string(key)
+	if !ok {
+		return 0, false
+	}
+	d, err := time.ParseDuration(v)
+	return d, err == nil
+}
+
+func (s settings) text() []byte {
+	return []byte(strings.Join(s.lines, "\n") + "\n")
+}
+
+// apply() - use the settings for the board run by m, before it starts running
+func (s settings) apply(m *machine) {
+	if d, ok := s.duration("clockSpeed"); ok {
+		m.clockSpeed = d
+	}
+	if seed, ok := s.int("seed"); ok {
+		m.reseed(seed)
+	}
+}
+
+// renderStyle() - how to draw the board, from the settings or else from -renderStyle
+func (s settings) renderStyle() string {
+	if style, ok := s.string("renderStyle"); ok {
+		return style
+	}
+	return *renderStyle
+}
+
+// update() - the settings with the ones which can change while running m, written when
+// there is already a sidecar or when they are no longer the defaults
+func (s settings) update(m *machine) settings {
+	if !s.empty() || m.clockSpeed != *clockSpeed {
+		s = s.set("clockSpeed", strconv.Quote(m.clockSpeed.String()))
+	}
+	if m.seeded {
+		s = s.set("seed", strconv.FormatInt(m.seed, 10))
+	}
+	return s
+}
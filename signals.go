@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gdamore/tcell"
+)
+
+// Signal flow colouring draws each wire in the colour of the value it carried in the last
+// tick, like a logic analyser laid over the board: dim for 0, bright for 1 and another
+// colour for the bigger digits. Wires which carried nothing keep the colour of their rune.
+
+// carried - the value each wire cell carried during a tick
+type carried map[coord]rune
+
+// signalColours - on unless turned off with :signals off
+type signalColours struct {
+	off bool
+}
+
+var theSignalColours = signalColours{}
+
+// carry() - note that the value went through the cell at p. Where signals meet on a wire
+// any value which isn't 0 is kept, as runeOR() does.
+func (m *machine) carry(p coord, value rune) {
+	if !isDigit(value) {
+		return // waiting for inputs, not a signal yet
+	}
+	if old, ok := m.carried[p]; ok && !isZero(old) {
+		return
+	}
+	m.carried[p] = value
+}
+
+// carried() - the values to colour the wires of pub by, nothing when off
+func (sc *signalColours) carried(pub *publication) carried {
+	if sc.off {
+		return nil
+	}
+	return pub.carried
+}
+
+// signalStyles - the style of each wire rune for each kind of value, made once
+var signalStyles = map[rune][3]tcell.Style{}
+
+func init() {
+	for _, r := range "-|@/\\~><" {
+		s := styleOf(r)
+		signalStyles[r] = [3]tcell.Style{
+			s.Foreground(tcell.ColorDarkSlateGray),
+			s.Foreground(tcell.ColorLime).Bold(true),
+			s.Foreground(tcell.ColorFuchsia).Bold(true),
+		}
+	}
+}
+
+// style() - the style of the wire r at p for the value it carried, or cellStyle if it isn't a
+// wire or carried nothing
+func (c carried) style(p coord, r rune, cellStyle tcell.Style) tcell.Style {
+	styles, wire := signalStyles[r]
+	if !wire {
+		return cellStyle
+	}
+	value, ok := c[p]
+	switch {
+	case !ok:
+		return cellStyle
+	case isZero(value):
+		return styles[0]
+	case value == '1':
+		return styles[1]
+	}
+	return styles[2]
+}
+
+// commandSignals() - :signals on|off, colour the wires by their values or by their runes
+func commandSignals(args []string) error {
+	if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+		return fmt.Errorf("usage: signals on|off")
+	}
+	theSignalColours.off = args[0] == "off"
+	return nil
+}
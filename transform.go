@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Rotating and mirroring a grid moves its cells and swaps the runes which have a direction.
+// Runes with no counterpart in the new direction, like gates, are left as they are, and
+// the editor says how many of them there were as the circuit no longer works the same.
+
+// rotateRunes - clockwise by 90 degrees
+var rotateRunes = map[rune]rune{
+	'-': '|',
+	'|': '-',
+}
+
+// mirrorLeftRightRunes - swap left and right
+var mirrorLeftRightRunes = map[rune]rune{
+	'>':  '<',
+	'<':  '>',
+	'/':  '\\',
+	'\\': '/',
+}
+
+// mirrorTopBottomRunes - swap top and bottom. A relay is controlled from above when it is
+// normally open and from below when it is normally closed, so mirroring changes its kind.
+var mirrorTopBottomRunes = map[rune]rune{
+	'L': 'J',
+	'J': 'L',
+	'S': 'Z',
+	'Z': 'S',
+}
+
+// The runes each transform moves without turning although they read an input or a value
+// from one side, or write one on a side, so they work differently afterwards
+const rotateOneWay = "<>/\\~.+#^=SZLJ*DCRM_"
+const mirrorLeftRightOneWay = "~.+#^=*DCRM"
+const mirrorTopBottomOneWay = "DM"
+
+func remap(r rune, runes map[rune]rune) rune {
+	if to, ok := runes[r]; ok {
+		return to
+	}
+	return r
+}
+
+func (g grid) width() int {
+	return len(g)
+}
+
+func (g grid) height() int {
+	if len(g) == 0 {
+		return 0
+	}
+	return len(g[0])
+}
+
+// rotate() - a copy of g turned 90 degrees clockwise
+func (g grid) rotate() grid {
+	t := makeGrid(g.height(), g.width())
+	for x := 0; x < g.width(); x++ {
+		for y := 0; y < g.height(); y++ {
+			t.set(g.height()-1-y, x, remap(g[x][y], rotateRunes))
+		}
+	}
+	return t
+}
+
+// mirrorLeftRight() - a copy of g flipped about a vertical line
+func (g grid) mirrorLeftRight() grid {
+	t := makeGrid(g.width(), g.height())
+	for x := 0; x < g.width(); x++ {
+		for y := 0; y < g.height(); y++ {
+			t.set(g.width()-1-x, y, remap(g[x][y], mirrorLeftRightRunes))
+		}
+	}
+	return t
+}
+
+// mirrorTopBottom() - a copy of g flipped about a horizontal line
+func (g grid) mirrorTopBottom() grid {
+	t := makeGrid(g.width(), g.height())
+	for x := 0; x < g.width(); x++ {
+		for y := 0; y < g.height(); y++ {
+			t.set(x, g.height()-1-y, remap(g[x][y], mirrorTopBottomRunes))
+		}
+	}
+	return t
+}
+
+// oneWay() - how many cells of g hold one of runes, and which of runes they are
+func (g grid) oneWay(runes string) (int, string) {
+	n, found := 0, make([]string, 0)
+	for _, r := range runes {
+		count := 0
+		for x := range g {
+			count += strings.Count(string(g[x]), string(r))
+		}
+		if count > 0 {
+			n += count
+			found = append(found, string(r))
+		}
+	}
+	return n, strings.Join(found, " ")
+}
+
+// warnOneWay() - say how many cells of g a transform done moves but can't turn
+func warnOneWay(done string, g grid, runes string) {
+	if n, found := g.oneWay(runes); n > 0 {
+		setMiddleMsg(fmt.Sprintf("%s, but %d work differently now: %s", done, n, found))
+	}
+}
+
+// rotateWarning() - rotate(), warning about the cells which don't turn
+func (g grid) rotateWarning() grid {
+	warnOneWay("Rotated", g, rotateOneWay)
+	return g.rotate()
+}
+
+// mirrorLeftRightWarning() - mirrorLeftRight(), warning about the cells which don't turn
+func (g grid) mirrorLeftRightWarning() grid {
+	warnOneWay("Mirrored", g, mirrorLeftRightOneWay)
+	return g.mirrorLeftRight()
+}
+
+// mirrorTopBottomWarning() - mirrorTopBottom(), warning about the cells which don't turn and
+// that the relays change kind
+func (g grid) mirrorTopBottomWarning() grid {
+	warnOneWay("Mirrored", g, mirrorTopBottomOneWay)
+	if relays, _ := g.oneWay("SZ"); relays > 0 {
+		setMiddleMsg(fmt.Sprintf("Relays swap between normally open 'S' and normally closed 'Z' when mirrored, %d changed", relays))
+	}
+	return g.mirrorTopBottom()
+}
+
+// transform() - apply fn to the selection of the snapshot b and return the edit which replaces
+// the selection with the result, or apply fn to the cut/paste buffer when nothing is selected
+func (e *editor) transform(b board, fn func(g grid) grid) edit {
+	if e.ks == KeysNormal {
+		setMiddleMsg("Transformed the paste buffer")
+		e.cutPasteBuffer = fn(e.cutPasteBuffer) // fn may say more
+		return nil
+	}
+	r := e.selectionRectangle
+	g := fn(b.gridOf(r))
+	// the transformed selection keeps its top left corner
+	e.selectionRectangle = rectangle{r.topLeft, coord{r.topLeft.x + g.width() - 1, r.topLeft.y + g.height() - 1}}
+	return func(b board) {
+		for x := r.topLeft.x; x <= r.bottomRight.x; x++ {
+			for y := r.topLeft.y; y <= r.bottomRight.y; y++ {
+				b.set(x, y, ' ')
+			}
+		}
+		// only what is in the cells, as a rotated selection may cover more of the board than before
+		for x := range g {
+			for y, c := range g[x] {
+				if !nonValue(c) {
+					b.set(r.topLeft.x+x, r.topLeft.y+y, c)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Truth tables run a copy of the board once for each combination of the '*' inputs in a
+// region, with their values set to 0 or 1, and read the lamps in the region once it settles.
+// The inputs and lamps to use are marked with a comment naming them, like _a_ 0* and L _q_.
+// Where none are marked, every input or every lamp in the region is used, named by where it is.
+
+// truthMaxInputs - more inputs than this would take too long to enumerate
+const truthMaxInputs = 12
+
+// signal - an input or output of a truth table
+type signal struct {
+	name   string
+	at     coord
+	value  coord // the cell holding its value
+	marked bool  // named by a comment
+}
+
+// comment() - the text between the underscores of a comment ending or starting at x on line y
+func comment(b board, x int, y int, step int) (string, bool) {
+	if b.get(x, y) != '_' {
+		return "", false
+	}
+	text := make([]rune, 0)
+	for i := x + step; i != x+step*40; i += step {
+		r := b.get(i, y)
+		switch {
+		case r == '_':
+			if len(text) == 0 {
+				return "", false
+			}
+			if step < 0 {
+				for l, r := 0, len(text)-1; l < r; l, r = l+1, r-1 {
+					text[l], text[r] = text[r], text[l]
+				}
+			}
+			return string(text), true
+		case nonValue(r):
+			return "", false
+		}
+		text = append(text, r)
+	}
+	return "", false
+}
+
+// truthSignals() - the '*' inputs and the lamps inside r which are marked, or all of them
+// when none are
+func truthSignals(b board, r rectangle) ([]signal, []signal) {
+	inputs, outputs := make([]signal, 0), make([]signal, 0)
+	b.eachOutsideComments(func(p coord, c rune) {
+		if !r.inside(p) {
+			return
+		}
+		switch c {
+		case '*':
+			name, ok := comment(b, p.x-3, p.y, -1) // _a_ 0*
+			if !ok {
+				name = fmt.Sprintf("*%d,%d", p.x, p.y)
+			}
+			inputs = append(inputs, signal{name, p, coord{p.x - 1, p.y}, ok})
+		case 'L', 'J':
+			name, ok := comment(b, p.x+2, p.y, 1) // L _q_
+			if !ok {
+				name = fmt.Sprintf("%c%d,%d", c, p.x, p.y)
+			}
+			value := coord{p.x, p.y - 1}
+			if c == 'J' {
+				value = coord{p.x, p.y + 1}
+			}
+			outputs = append(outputs, signal{name, p, value, ok})
+		}
+	})
+	return onlyMarked(inputs), onlyMarked(outputs)
+}
+
+// onlyMarked() - the marked signals, or all of them when none are marked
+func onlyMarked(signals []signal) []signal {
+	marked := make([]signal, 0, len(signals))
+	for _, s := range signals {
+		if s.marked {
+			marked = append(marked, s)
+		}
+	}
+	if len(marked) == 0 {
+		return signals
+	}
+	return marked
+}
+
+// truthTable() - the outputs for each combination of the inputs inside r of b, as text.
+// Before each row goOn is told how many rows are done and stops the table if it returns false.
+func truthTable(b board, r rectangle, goOn func(done int, rows int) bool) (string, error) {
+	inputs, outputs := truthSignals(b, r)
+	switch {
+	case len(inputs) == 0:
+		return "", fmt.Errorf("no '*' inputs to enumerate")
+	case len(inputs) > truthMaxInputs:
+		return "", fmt.Errorf("%d inputs is too many, at most %d - mark them like _a_ 0* or select fewer", len(inputs), truthMaxInputs)
+	case len(outputs) == 0:
+		return "", fmt.Errorf("no lamps to read")
+	}
+	var t strings.Builder
+	// row() - a line of the table, each cell as wide as the name of its column
+	row := func(in []string, out []string) {
+		var line strings.Builder
+		for i, s := range inputs {
+			fmt.Fprintf(&line, " %-*s", maxInt(1, len(s.name)), in[i])
+		}
+		line.WriteString(" |")
+		for i, s := range outputs {
+			fmt.Fprintf(&line, " %-*s", maxInt(1, len(s.name)), out[i])
+		}
+		t.WriteString(strings.TrimRight(line.String(), " ") + "\n")
+	}
+	names := func(signals []signal) []string {
+		n := make([]string, len(signals))
+		for i, s := range signals {
+			n[i] = s.name
+		}
+		return n
+	}
+	row(names(inputs), names(outputs))
+	unsettled := 0
+	for combo := 0; combo < 1<<len(inputs); combo++ {
+		if !goOn(combo, 1<<len(inputs)) {
+			return "", nil
+		}
+		c := makeBoard()
+		b.copyInto(c)
+		in := make([]string, len(inputs))
+		for i, s := range inputs {
+			bit := (combo >> (len(inputs) - 1 - i)) & 1 // the first input changes slowest
+			in[i] = strconv.Itoa(bit)
+			c.setC(s.value, int2Rune(bit))
+		}
+		_, settled := headlessMachine(c).settle(c)
+		out := make([]string, len(outputs))
+		for i, s := range outputs {
+			out[i] = string(toBinary(c.getC(s.value)))
+			if !settled {
+				out[i] = "~"
+			}
+		}
+		if !settled {
+			unsettled++
+		}
+		row(in, out)
+	}
+	if unsettled > 0 {
+		fmt.Fprintf(&t, "~ did not settle in %d ticks, %d of %d rows\n", settleLimit, unsettled, 1<<len(inputs))
+	}
+	return t.String(), nil
+}
+
+// commandTruth() - :truth [file], the truth table of the selection or else the board,
+// shown over the board or saved to file
+func commandTruth(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: truth [file]")
+	}
+	b := makeBoard()
+	snapshot(func(s board, _ int) {
+		s.copyInto(b)
+	})
+	r := b.extent()
+	if theEditor.ks != KeysNormal {
+		r = theEditor.selectionRectangle
+	}
+	var table string
+	return background("Truth table", func(j *job) error {
+		var err error
+		table, err = truthTable(b, r, func(done int, rows int) bool {
+			j.progress("row %d of %d", done, rows)
+			return !j.cancelled()
+		})
+		if err != nil || len(args) == 0 {
+			return err
+		}
+		return replaceFile(args[0], []byte(table))
+	}, func() error {
+		if len(args) == 1 {
+			setMiddleMsg(fmt.Sprintf("Saved the truth table to %s", args[0]))
+			return nil
+		}
+		theCommandLine.help = strings.Split(strings.TrimSuffix(table, "\n"), "\n")
+		return nil
+	})
+}
+
+// truthMain() - betula truth board.betula [x1 y1 x2 y2], printing the truth table of the board or the region
+func truthMain(args []string) error {
+	if len(args) != 1 && len(args) != 5 {
+		return fmt.Errorf("usage: betula truth board.betula [x1 y1 x2 y2]")
+	}
+	headlessInit()
+	b, _, err := loadFile(args[0])
+	if err != nil {
+		return err
+	}
+	r := b.extent()
+	if len(args) == 5 {
+		n := make([]int, 4)
+		for i, a := range args[1:] {
+			if n[i], err = strconv.Atoi(a); err != nil {
+				return fmt.Errorf("expected a number, not %s", a)
+			}
+		}
+		r = newRectangle(n[0], n[1], n[2], n[3])
+	}
+	table, err := truthTable(b, r, func(int, int) bool {
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.WriteString(table)
+	return err
+}
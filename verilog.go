@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Structural Verilog from the netlist of a board, to check it against another simulator
+// or take it to an FPGA. Signals become single bits, every value but 0 being 1, and
+// each rising edge of clk is one tick of the board.
+
+// verilogGates - the expression for each gate of its top and bottom inputs
+var verilogGates = map[rune]string{
+	'.': "%s & %s",
+	'+': "%s | %s",
+	'#': "%s ^ %s",
+	'^': "~(%s & %s)",
+	'=': "~(%s ^ %s)",
+}
+
+func verilogCoord(p coord) string {
+	return strings.ReplaceAll(fmt.Sprintf("%d_%d", p.x, p.y), "-", "m")
+}
+
+// verilogName() - the name of the output of the component r at p
+func verilogName(r rune, p coord) string {
+	names := map[rune]string{'*': "in", 'C': "clock", 'R': "random", 'N': "not", 'D': "delay", 'L': "lamp", 'J': "lamp"}
+	name, ok := names[r]
+	if !ok {
+		name = "gate"
+	}
+	return name + "_" + verilogCoord(p)
+}
+
+var notIdentifier = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// verilogKeywords - the ones which make likely board names, like xor.betula
+var verilogKeywords = map[string]bool{
+	"and": true, "or": true, "xor": true, "nand": true, "nor": true, "xnor": true, "not": true, "buf": true,
+	"module": true, "input": true, "output": true, "wire": true, "reg": true, "assign": true, "always": true,
+}
+
+func verilogModuleName(filename string) string {
+	name := notIdentifier.ReplaceAllString(strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename)), "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') || verilogKeywords[name] {
+		name = "board_" + name
+	}
+	return name
+}
+
+// verilog() - the board loaded from filename as a module named after it, and what could not be exported
+func verilog(b board, filename string) (string, []problem) {
+	n := makeNetlist(b)
+	problems := make([]problem, 0)
+	report := func(p coord, format string, args ...interface{}) {
+		problems = append(problems, problem{p, fmt.Sprintf(format, args...)})
+	}
+	// signal() - the expression for what reaches the component at p from the cells from
+	signal := func(p coord, what string, from ...coord) string {
+		names := make([]string, 0)
+		seen := map[coord]bool{}
+		diodes := true
+		for _, f := range from {
+			for _, a := range n.inputs(p, f) {
+				if !seen[a.driver] {
+					seen[a.driver] = true
+					names = append(names, verilogName(b.getC(a.driver), a.driver))
+					diodes = diodes && a.viaDiode
+				}
+			}
+		}
+		switch {
+		case len(names) == 0:
+			report(p, "nothing drives the %s of '%c', exported as 0", what, b.getC(p))
+			return "1'b0"
+		case len(names) == 1:
+			return names[0]
+		case !diodes:
+			report(p, "%d signals drive the %s of '%c' without diodes, exported as their OR", len(names), what, b.getC(p))
+		}
+		return "(" + strings.Join(names, " | ") + ")"
+	}
+
+	inputs := make([]string, 0)
+	outputs := make([]string, 0)
+	wires := make([]string, 0)
+	assigns := make([]string, 0)
+	clocked := false
+	for _, p := range n.components() {
+		r := b.getC(p)
+		name := verilogName(r, p)
+		at := fmt.Sprintf("// '%c' at %d %d", r, p.x, p.y)
+		top, bottom, left := coord{p.x, p.y - 1}, coord{p.x, p.y + 1}, coord{p.x - 1, p.y}
+		switch {
+		case r == '*':
+			inputs = append(inputs, fmt.Sprintf("input wire %s, %s, %c on the board", name, at, toBinary(b.getC(left))))
+		case r == 'R':
+			inputs = append(inputs, fmt.Sprintf("input wire %s, %s", name, at))
+			report(p, "random values come in on input %s", name)
+		case r == 'C':
+			clocked = true
+			modulo, fraction := 2, 4
+			if m := b.getC(left); isDigit(m) {
+				if modulo = rune2Int(m); modulo == 0 {
+					modulo = 36
+				}
+				if f := b.get(p.x-2, p.y); isDigit(f) {
+					fraction = rune2Int(f)
+				}
+			}
+			wires = append(wires, fmt.Sprintf("wire %s; %s", name, at))
+			assigns = append(assigns, fmt.Sprintf("assign %s = ((ticks >> %d) %% %d) != 0;", name, fraction, modulo))
+		case gateRunes[r]:
+			wires = append(wires, fmt.Sprintf("wire %s; %s", name, at))
+			assigns = append(assigns, fmt.Sprintf("assign %s = "+verilogGates[r]+";", name, signal(p, "top", top), signal(p, "bottom", bottom)))
+		case r == 'N':
+			wires = append(wires, fmt.Sprintf("wire %s; %s", name, at))
+			sides := []coord{top, bottom, left, {p.x + 1, p.y}}
+			assigns = append(assigns, fmt.Sprintf("assign %s = ~%s;", name, signal(p, "input", sides...)))
+		case r == 'L' || r == 'J':
+			sides := make([]coord, 0)
+			for _, a := range n.arrivals[p] {
+				sides = append(sides, a.from)
+			}
+			outputs = append(outputs, fmt.Sprintf("output wire %s, %s", name, at))
+			assigns = append(assigns, fmt.Sprintf("assign %s = %s;", name, signal(p, "lamp", uniqueCoords(sides)...)))
+		case r == 'D':
+			wires = append(wires, fmt.Sprintf("wire %s; %s", name, at))
+			assigns = append(assigns, fmt.Sprintf("assign %s = %s;", name, signal(p, "input", left)))
+			report(p, "delays are exported as plain wires")
+		case r == 'S' || r == 'Z':
+			report(p, "relays can't be exported, what they switch is left undriven")
+		case r == 'E' || r == 'B':
+			report(p, "'%c' has no equivalent and is left out", r)
+		}
+	}
+	if clocked {
+		inputs = append([]string{"input wire clk, // one tick of the board"}, inputs...)
+	}
+	ports := append(inputs, outputs...)
+
+	var v strings.Builder
+	module := verilogModuleName(filename)
+	fmt.Fprintf(&v, "// %s exported by betula. Signals are one bit, every value but 0 is 1.\n", filepath.Base(filename))
+	for _, pr := range problems {
+		fmt.Fprintf(&v, "// %s\n", pr)
+	}
+	fmt.Fprintf(&v, "module %s (\n", module)
+	for i, port := range ports {
+		if i == len(ports)-1 {
+			port = strings.Replace(port, ", //", " //", 1) // no comma after the last port
+		}
+		fmt.Fprintf(&v, "\t%s\n", port)
+	}
+	v.WriteString(");\n")
+	if clocked {
+		v.WriteString("\treg [31:0] ticks = 0;\n\talways @(posedge clk) ticks <= ticks + 1;\n")
+	}
+	for _, w := range wires {
+		fmt.Fprintf(&v, "\t%s\n", w)
+	}
+	for _, a := range assigns {
+		fmt.Fprintf(&v, "\t%s\n", a)
+	}
+	v.WriteString("endmodule\n")
+	return v.String(), problems
+}
+
+func uniqueCoords(cs []coord) []coord {
+	seen := map[coord]bool{}
+	unique := make([]coord, 0, len(cs))
+	for _, c := range cs {
+		if !seen[c] {
+			seen[c] = true
+			unique = append(unique, c)
+		}
+	}
+	return unique
+}
+
+// problemLines() - problems as lines for the help overlay
+func problemLines(title string, problems []problem) []string {
+	lines := []string{" " + title + " "}
+	for _, p := range problems {
+		lines = append(lines, " "+p.String()+" ")
+	}
+	return lines
+}
+
+// commandVerilog() - :verilog file.v, listing what could not be exported
+func commandVerilog(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: verilog file.v")
+	}
+	var text string
+	var problems []problem
+	filename := current().filename
+	snapshot(func(b board, _ int) {
+		text, problems = verilog(b, filename)
+	})
+	if err := replaceFile(args[0], []byte(text)); err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		theCommandLine.help = problemLines(fmt.Sprintf("Exported %s with %d problems:", args[0], len(problems)), problems)
+	}
+	setMiddleMsg(fmt.Sprintf("Exported %s", args[0]))
+	return nil
+}
+
+// verilogMain() - betula verilog board.betula file.v, without the editor
+func verilogMain(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: betula verilog board.betula file.v")
+	}
+	headlessInit()
+	b, _, err := loadFile(args[0])
+	if err != nil {
+		return err
+	}
+	makeMachine(b).expandMacros(b)
+	text, problems := verilog(b, args[0])
+	for _, p := range problems {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", args[0], p)
+	}
+	return replaceFile(args[1], []byte(text))
+}